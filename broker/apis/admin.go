@@ -0,0 +1,122 @@
+package apis
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/jasony62/tms-go-apihub/core"
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+func adminListJobs(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, core.ListJobs(c.Query("status")))
+}
+
+func adminGetJob(c *gin.Context) {
+	job, ok := core.GetJob(c.Param("jobId"))
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, util.CreateTmsError(hub.TmsErrorApisId, "job不存在："+c.Param("jobId"), nil))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, job)
+}
+
+func adminCancelJob(c *gin.Context) {
+	job, ok := core.CancelJob(c.Param("jobId"))
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, util.CreateTmsError(hub.TmsErrorApisId, "job不存在："+c.Param("jobId"), nil))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, job)
+}
+
+func adminListTriggers(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, core.ListTriggers())
+}
+
+func adminReloadTriggers(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, core.ReloadTriggers())
+}
+
+func adminTriggerResult(c *gin.Context, info map[string]interface{}, ok bool) {
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, util.CreateTmsError(hub.TmsErrorApisId, "trigger不存在："+c.Param("name"), nil))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, info)
+}
+
+func adminGetTrigger(c *gin.Context) {
+	info, ok := core.GetTrigger(c.Param("name"))
+	adminTriggerResult(c, info, ok)
+}
+
+func adminEnableTrigger(c *gin.Context) {
+	info, ok := core.EnableTrigger(c.Param("name"), true)
+	adminTriggerResult(c, info, ok)
+}
+
+func adminDisableTrigger(c *gin.Context) {
+	info, ok := core.EnableTrigger(c.Param("name"), false)
+	adminTriggerResult(c, info, ok)
+}
+
+func adminRunTrigger(c *gin.Context) {
+	info, ok := core.RunTrigger(c.Param("name"))
+	adminTriggerResult(c, info, ok)
+}
+
+func adminListClients(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, listHttpClients())
+}
+
+func adminCheckConf(c *gin.Context) {
+	problems := core.ValidateConf()
+	c.IndentedJSON(http.StatusOK, map[string]interface{}{"count": len(problems), "problems": problems})
+}
+
+const adminTokenHeader = "X-Apihub-Admin-Token"
+
+// 配置token时请求头中需要带有相同的token，没有配置时只允许本机和内网地址访问
+func adminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(token) > 0 {
+			if subtle.ConstantTimeCompare([]byte(c.GetHeader(adminTokenHeader)), []byte(token)) == 1 {
+				return
+			}
+			str := "管理接口token无效"
+			logger.LogS().Errorln(str, " ip:", c.ClientIP(), " path:", c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, util.CreateTmsError(hub.TmsErrorApisId, str, nil))
+			return
+		}
+
+		//不使用X-Forwarded-For，只检查直接连接的地址
+		if ip, _ := c.RemoteIP(); ip != nil && (ip.IsLoopback() || ip.IsPrivate()) {
+			return
+		}
+		str := "管理接口只允许本机和内网地址访问"
+		logger.LogS().Errorln(str, " ip:", c.Request.RemoteAddr, " path:", c.Request.URL.Path)
+		c.AbortWithStatusJSON(http.StatusForbidden, util.CreateTmsError(hub.TmsErrorApisId, str, nil))
+	}
+}
+
+// 管理接口，只有在apiGateway配置admin时才开启
+func registerAdminRoutes(router *gin.Engine, token string) {
+	admin := router.Group("/admin", adminAuth(token))
+	admin.GET("/jobs", adminListJobs)
+	admin.GET("/jobs/:jobId", adminGetJob)
+	admin.DELETE("/jobs/:jobId", adminCancelJob)
+	admin.POST("/jobs/:jobId/cancel", adminCancelJob)
+	admin.GET("/triggers", adminListTriggers)
+	admin.POST("/triggers/reload", adminReloadTriggers)
+	admin.GET("/triggers/:name", adminGetTrigger)
+	admin.POST("/triggers/:name/enable", adminEnableTrigger)
+	admin.POST("/triggers/:name/disable", adminDisableTrigger)
+	admin.POST("/triggers/:name/run", adminRunTrigger)
+	admin.GET("/clients", adminListClients)
+	admin.GET("/conf/check", adminCheckConf)
+}
@@ -0,0 +1,48 @@
+package apis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	//通过检查后因为trigger不存在返回404
+	tests := []struct {
+		name       string
+		token      string
+		remoteAddr string
+		header     string
+		code       int
+	}{
+		{"loopback", "", "127.0.0.1:1234", "", http.StatusNotFound},
+		{"private", "", "192.168.1.10:1234", "", http.StatusNotFound},
+		{"public", "", "8.8.8.8:1234", "", http.StatusForbidden},
+		{"token", "secret", "8.8.8.8:1234", "secret", http.StatusNotFound},
+		{"wrong token", "secret", "127.0.0.1:1234", "other", http.StatusUnauthorized},
+		{"missing token", "secret", "127.0.0.1:1234", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			registerAdminRoutes(router, tt.token)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/triggers/test-admin/run", nil)
+			req.RemoteAddr = tt.remoteAddr
+			//X-Forwarded-For不影响地址判断
+			req.Header.Set("X-Forwarded-For", "127.0.0.1")
+			if len(tt.header) > 0 {
+				req.Header.Set(adminTokenHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.code {
+				t.Fatalf("code = %d, want %d, body: %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
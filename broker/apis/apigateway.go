@@ -1,10 +1,12 @@
 package apis
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jasony62/tms-go-apihub/core"
@@ -22,6 +24,8 @@ type app struct {
 	httpApi      string
 	postOK       string
 	postNOK      string
+	trace        string
+	dryRun       bool
 }
 
 var defaultApp = app{
@@ -30,15 +34,18 @@ var defaultApp = app{
 	httpApi:      "_APIGATEWAY_HTTPAPI",
 	postOK:       "_APIGATEWAY_POST_OK",
 	postNOK:      "_APIGATEWAY_POST_NOK",
+	trace:        traceNone,
 }
 
 func fillStats(stack *hub.Stack, result interface{}, code int) {
-	stats := make(map[string]string)
-	stack.Heap[hub.HeapStatsName] = stats
+	//请求被取消或者超时后，后续的统计和通知处理仍需要执行
+	stack.Context = context.Background()
 
+	stats := make(map[string]string)
 	stats["child"] = ""
 	stats["duration"] = strconv.FormatFloat(time.Since(stack.StartTime).Seconds(), 'f', 5, 64)
 	stats["code"] = strconv.FormatInt(int64(code), 10)
+	stack.Heap.Set(hub.HeapStatsName, stats)
 }
 
 // 1次请求的上下文
@@ -47,7 +54,12 @@ func newStack(c *gin.Context, level string) (*hub.Stack, string) {
 	// 收到的数据
 	var value interface{}
 	inReqData := new(interface{})
-	c.ShouldBindJSON(&inReqData)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		//上传的文件保留在请求中，通过file的upload读取，表单字段作为origin
+		*inReqData = getMultipartValues(c)
+	} else {
+		c.ShouldBindJSON(&inReqData)
+	}
 
 	if *inReqData == nil {
 		value = make(map[string]interface{})
@@ -67,12 +79,18 @@ func newStack(c *gin.Context, level string) (*hub.Stack, string) {
 
 	base := map[string]interface{}{"root": name, "type": level, "start": strconv.FormatInt(now.Unix(), 10), "src": c.ClientIP()}
 
-	return &hub.Stack{
+	stack := &hub.Stack{
 		GinContext: c,
-		Heap:       map[string]interface{}{hub.HeapOriginName: value, hub.HeapBaseName: base},
-		BaseString: util.CreateBaseString(base),
+		Context:    c.Request.Context(),
+		Heap:       hub.NewHeap(map[string]interface{}{hub.HeapOriginName: value, hub.HeapBaseName: base}),
 		StartTime:  now,
-	}, name
+	}
+	if isDryRunRequest(stack) {
+		stack.DryRun = true
+		base["dryRun"] = "true"
+	}
+	stack.BaseString = util.CreateBaseString(base)
+	return stack, name
 }
 
 func callCommon(stack *hub.Stack, command string, content string) {
@@ -130,12 +148,14 @@ func callCommon(stack *hub.Stack, command string, content string) {
 // 执行1个API调用
 func callHttpApi(c *gin.Context) {
 	stack, _ := newStack(c, "httpapi")
+	defer traceRequest(stack)()
 	callCommon(stack, "flowApi", defaultApp.httpApi)
 }
 
 // 执行一个调用流程
 func callFlow(c *gin.Context) {
 	stack, name := newStack(c, "flow")
+	defer traceRequest(stack)()
 	// 执行编排
 	callCommon(stack, "flowApi", name)
 }
@@ -143,12 +163,14 @@ func callFlow(c *gin.Context) {
 // 执行一个计划流程
 func callSchedule(c *gin.Context) {
 	stack, name := newStack(c, "schedule")
+	defer traceRequest(stack)()
 	// 执行编排
 	callCommon(stack, "scheduleApi", name)
 }
 
 func apiGatewayRun(host string, portString string, bucketEnable string,
-	pre string, postOK string, postNOK string, httpApi string) {
+	pre string, postOK string, postNOK string, httpApi string, adminEnable string, adminToken string,
+	trace string, traceSize string, dryRun string) {
 	var port int
 	if len(host) == 0 {
 		host = "0.0.0.0"
@@ -201,6 +223,21 @@ func apiGatewayRun(host string, portString string, bucketEnable string,
 		}
 	}
 
+	switch trace {
+	case traceAll, traceHeader:
+		defaultApp.trace = trace
+		size, _ := strconv.Atoi(traceSize)
+		core.SetTraceSize(size)
+	case "", traceNone:
+	default:
+		logger.LogS().Errorln("无效的trace配置：", trace)
+	}
+	logger.LogS().Infoln("trace ", defaultApp.trace)
+
+	//dry-run会返回生成的请求，默认关闭
+	defaultApp.dryRun = isFlagEnabled(dryRun)
+	logger.LogS().Infoln("dryRun ", defaultApp.dryRun)
+
 	router := gin.New()
 	// 注册zap中间件,使用zap日志处理Gin日志
 	router.Use(logger.GinLogger(), logger.GinRecovery(true))
@@ -220,6 +257,14 @@ func apiGatewayRun(host string, portString string, bucketEnable string,
 		router.Any("/schedule/:Id", callSchedule)
 		router.Any("/schedule/:Id/:version", callSchedule)
 	}
+	if len(adminEnable) > 0 && regexp.MustCompile(`(?i)yes|true`).MatchString(adminEnable) {
+		logger.LogS().Infoln("admin enable")
+		registerAdminRoutes(router, adminToken)
+	}
+	if defaultApp.trace != traceNone {
+		registerDebugRoutes(router)
+	}
+
 	basePath := util.GetBasePath() + "templates"
 	if needLoad, _ := util.PathExists(basePath); needLoad {
 		router.LoadHTMLGlob(basePath + "/*.tmpl")
@@ -234,6 +279,7 @@ func apiGatewayRun(host string, portString string, bucketEnable string,
 
 func apiGateway(stack *hub.Stack, params map[string]string) (interface{}, int) {
 	apiGatewayRun(params["host"], params["port"], params["bucket"],
-		params["pre"], params["postOK"], params["postNOK"], params["httpApi"])
+		params["pre"], params["postOK"], params["postNOK"], params["httpApi"], params["admin"], params["adminToken"],
+		params["trace"], params["traceSize"], params["dryRun"])
 	return nil, http.StatusOK
 }
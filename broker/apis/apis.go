@@ -31,4 +31,23 @@ func ApisInit() {
 		"logOutput":             logOutput,
 		"apiSleep":              apiSleep,
 	})
+
+	dryRunInit()
+	core.RegisterHttpMiddleware("stats", 0, httpStatsMiddleware)
+
+	core.RegisterConfRefs(map[string]map[string]hub.ConfRefDef{
+		"httpApi": {
+			"name":    {Type: hub.JSON_TYPE_API},
+			"private": {Type: hub.JSON_TYPE_PRIVATE},
+			//postHttpapis固定调用的flow
+			"_postOK":  {Type: hub.JSON_TYPE_FLOW, Default: "_HTTPOK"},
+			"_postNOK": {Type: hub.JSON_TYPE_FLOW, Default: "_HTTPNOK"},
+		},
+		"apiGateway": {
+			"pre":     {Type: hub.JSON_TYPE_FLOW, Default: defaultApp.pre},
+			"httpApi": {Type: hub.JSON_TYPE_FLOW, Default: defaultApp.httpApi},
+			"postOK":  {Type: hub.JSON_TYPE_FLOW, Default: defaultApp.postOK},
+			"postNOK": {Type: hub.JSON_TYPE_FLOW, Default: defaultApp.postNOK},
+		},
+	})
 }
@@ -15,7 +15,7 @@ func fillBaseInfo(stack *hub.Stack, params map[string]string) (interface{}, int)
 	 * "type":"flow"
 	 * "start":"1659432682"
 	 */
-	base := stack.Heap[hub.HeapBaseName].(map[string]interface{})
+	base := hub.GetHeapMap[interface{}](stack.Heap, hub.HeapBaseName)
 
 	if base == nil {
 		str := "获得Base map失败" + hub.HeapBaseName
@@ -26,14 +26,17 @@ func fillBaseInfo(stack *hub.Stack, params map[string]string) (interface{}, int)
 	 * "uuid":""
 	 */
 	for k, v := range params {
-		base[k] = v
+		hub.SetHeapMapValue[interface{}](stack.Heap, hub.HeapBaseName, k, v)
 	}
 
 	// 若json未提供uuid，创建uuid字符串，作为唯一请求标识符，例如："e1b86e64-b26c-4b7f-bdd1-7ef9492b8780"
+	// 开启trace时网关已经创建了uuid
 	if len(params["uuid"]) == 0 {
-		base["uuid"] = uuid.New().String()
+		if id, _ := base["uuid"].(string); len(id) == 0 {
+			hub.SetHeapMapValue[interface{}](stack.Heap, hub.HeapBaseName, "uuid", uuid.New().String())
+		}
 	}
 
-	stack.BaseString = util.CreateBaseString(base)
+	stack.BaseString = util.CreateBaseString(hub.GetHeapMap[interface{}](stack.Heap, hub.HeapBaseName))
 	return nil, http.StatusOK
 }
@@ -0,0 +1,204 @@
+package apis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+	"github.com/valyala/fasthttp"
+)
+
+// HTTPAPI没有指定client时使用的配置名称，clients目录下没有同名文件时使用fasthttp的默认值
+const defaultHttpClientName = "default"
+
+var defaultHttpClientDef = &hub.HttpClientDef{Name: defaultHttpClientName}
+
+// 连接池超过空闲时间后删除，dynamicUrl访问的上游地址不会一直累积
+const httpClientPoolIdleTTL = 10 * time.Minute
+
+// 连接池数量超过上限时记录告警，空闲时间内使用过的连接池可能正在发送请求，不会删除
+const maxHttpClientPools = 1000
+
+// 1个client配置访问1个上游地址的连接池
+type httpClientPool struct {
+	name     string
+	addr     string
+	def      *hub.HttpClientDef
+	client   *fasthttp.HostClient
+	lastUse  time.Time //获取连接池时更新，由httpClientLock保护
+	requests uint64
+	errors   uint64
+	timeouts uint64
+}
+
+var httpClientLock sync.Mutex
+var httpClientPools = make(map[string]*httpClientPool)
+
+func getHttpClientDef(name string) (string, *hub.HttpClientDef, error) {
+	if len(name) == 0 {
+		name = defaultHttpClientName
+		if def, ok := util.FindHttpClientDef(name); ok {
+			return name, def, nil
+		}
+		return name, defaultHttpClientDef, nil
+	}
+
+	def, ok := util.FindHttpClientDef(name)
+	if !ok {
+		str := "获得client定义失败：" + name
+		logger.LogS().Errorln(str)
+		return name, nil, util.NewStatusError(hub.TmsErrorApisId, http.StatusInternalServerError, str, nil)
+	}
+	return name, def, nil
+}
+
+func millisecond(value int) time.Duration {
+	return time.Duration(value) * time.Millisecond
+}
+
+func newHostClient(def *hub.HttpClientDef, addr string, isTLS bool) *fasthttp.HostClient {
+	dialer := &fasthttp.TCPDialer{Concurrency: 1000, DNSCacheDuration: millisecond(def.DnsCacheDuration)}
+	dial := dialer.Dial
+	if def.DialTimeout > 0 {
+		dial = func(addr string) (net.Conn, error) {
+			return dialer.DialTimeout(addr, millisecond(def.DialTimeout))
+		}
+	}
+
+	return &fasthttp.HostClient{
+		Addr:                addr,
+		IsTLS:               isTLS,
+		Dial:                dial,
+		ReadTimeout:         millisecond(def.ReadTimeout),
+		WriteTimeout:        millisecond(def.WriteTimeout),
+		MaxConns:            def.MaxConnsPerHost,
+		MaxIdleConnDuration: millisecond(def.MaxIdleConnDuration),
+		MaxConnWaitTimeout:  millisecond(def.MaxConnWaitTimeout),
+		//path参数中转义的字符需要原样发送
+		DisablePathNormalizing: true,
+	}
+}
+
+// 按照client名称和请求的上游地址获取共享的连接池，client配置重新加载后创建新的连接池
+func getHttpClientPool(name string, req *fasthttp.Request) (*httpClientPool, error) {
+	name, def, err := getHttpClientDef(name)
+	if err != nil {
+		return nil, err
+	}
+
+	uri := req.URI()
+	scheme := strings.ToLower(string(uri.Scheme()))
+	host := string(uri.Host())
+	if len(host) == 0 {
+		str := "无效的上游地址：" + uri.String()
+		logger.LogS().Errorln(str)
+		return nil, util.NewStatusError(hub.TmsErrorApisId, http.StatusInternalServerError, str, nil)
+	}
+	isTLS := scheme == "https"
+	if !strings.Contains(host, ":") {
+		if isTLS {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+	addr := scheme + "://" + host
+	key := name + " " + addr
+
+	now := time.Now()
+	httpClientLock.Lock()
+	defer httpClientLock.Unlock()
+	pool := httpClientPools[key]
+	if pool != nil && pool.def == def {
+		pool.lastUse = now
+		return pool, nil
+	}
+	if pool != nil {
+		pool.client.CloseIdleConnections()
+	}
+	evictHttpClientPools(now)
+	pool = &httpClientPool{name: name, addr: addr, def: def, client: newHostClient(def, host, isTLS), lastUse: now}
+	httpClientPools[key] = pool
+	logger.LogS().Infoln("创建连接池，client:", name, " addr:", addr)
+	return pool, nil
+}
+
+// 创建新的连接池前删除空闲超时的连接池，调用时需要持有httpClientLock。
+// 获取连接池后到发出请求前PendingRequests仍然为0，所以空闲时间内使用过的连接池都不删除。
+func evictHttpClientPools(now time.Time) {
+	for key, pool := range httpClientPools {
+		if pool.client.PendingRequests() > 0 || now.Sub(pool.lastUse) <= httpClientPoolIdleTTL {
+			continue
+		}
+		removeHttpClientPool(key, pool)
+	}
+
+	if len(httpClientPools) >= maxHttpClientPools {
+		logger.LogS().Warnln("连接池数量达到上限：", len(httpClientPools), "，空闲时间内使用过的连接池不删除")
+	}
+}
+
+func removeHttpClientPool(key string, pool *httpClientPool) {
+	delete(httpClientPools, key)
+	pool.client.CloseIdleConnections()
+	logger.LogS().Infoln("删除连接池，client:", pool.name, " addr:", pool.addr)
+}
+
+func (p *httpClientPool) record(err error) {
+	atomic.AddUint64(&p.requests, 1)
+	if err == nil {
+		return
+	}
+	atomic.AddUint64(&p.errors, 1)
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		atomic.AddUint64(&p.timeouts, 1)
+	}
+}
+
+func (p *httpClientPool) info() map[string]interface{} {
+	return map[string]interface{}{
+		"client":   p.name,
+		"addr":     p.addr,
+		"conns":    p.client.ConnsCount(),
+		"pending":  p.client.PendingRequests(),
+		"requests": atomic.LoadUint64(&p.requests),
+		"errors":   atomic.LoadUint64(&p.errors),
+		"timeouts": atomic.LoadUint64(&p.timeouts),
+		"lastUse":  p.client.LastUseTime(),
+	}
+}
+
+func getHttpClientPools() []*httpClientPool {
+	httpClientLock.Lock()
+	defer httpClientLock.Unlock()
+
+	keys := make([]string, 0, len(httpClientPools))
+	for k := range httpClientPools {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]*httpClientPool, len(keys))
+	for i, k := range keys {
+		result[i] = httpClientPools[k]
+	}
+	return result
+}
+
+// 返回所有连接池的统计信息
+func listHttpClients() []map[string]interface{} {
+	pools := getHttpClientPools()
+	result := make([]map[string]interface{}, len(pools))
+	for i, pool := range pools {
+		result[i] = pool.info()
+	}
+	return result
+}
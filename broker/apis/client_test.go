@@ -0,0 +1,85 @@
+package apis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func getTestPool(t *testing.T, url string) *httpClientPool {
+	t.Helper()
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(url)
+	pool, err := getHttpClientPool("", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func resetTestPools() {
+	httpClientLock.Lock()
+	httpClientPools = make(map[string]*httpClientPool)
+	httpClientLock.Unlock()
+}
+
+func TestHttpClientPoolReuse(t *testing.T) {
+	resetTestPools()
+	defer resetTestPools()
+
+	a := getTestPool(t, "http://a.example/x")
+	if getTestPool(t, "http://a.example:80/y") != a {
+		t.Fatal("same addr should share a pool")
+	}
+	if getTestPool(t, "https://a.example/x") == a {
+		t.Fatal("https should use another pool")
+	}
+}
+
+func TestHttpClientPoolIdleEviction(t *testing.T) {
+	resetTestPools()
+	defer resetTestPools()
+
+	idle := getTestPool(t, "http://idle.example/")
+	httpClientLock.Lock()
+	idle.lastUse = time.Now().Add(-2 * httpClientPoolIdleTTL)
+	httpClientLock.Unlock()
+
+	getTestPool(t, "http://new.example/")
+	if len(getHttpClientPools()) != 1 || getTestPool(t, "http://idle.example/") == idle {
+		t.Fatal("idle pool not evicted")
+	}
+}
+
+// 超过上限时只删除空闲超时的连接池，刚获取还没有发出请求的连接池不删除
+func TestHttpClientPoolCapKeepsRecentPools(t *testing.T) {
+	resetTestPools()
+	defer resetTestPools()
+
+	first := getTestPool(t, "http://host0.example/")
+	idle := getTestPool(t, "http://idle.example/")
+	httpClientLock.Lock()
+	idle.lastUse = time.Now().Add(-2 * httpClientPoolIdleTTL)
+	httpClientLock.Unlock()
+	for i := 1; i <= maxHttpClientPools; i++ {
+		getTestPool(t, "http://host"+strconv.Itoa(i)+".example/")
+	}
+
+	pools := getHttpClientPools()
+	if len(pools) != maxHttpClientPools+1 {
+		t.Fatalf("pools = %d", len(pools))
+	}
+	found := false
+	for _, pool := range pools {
+		if pool == idle {
+			t.Fatal("idle pool not evicted")
+		}
+		found = found || pool == first
+	}
+	if !found {
+		t.Fatal("recently used pool evicted")
+	}
+}
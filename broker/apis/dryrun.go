@@ -0,0 +1,134 @@
+package apis
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jasony62/tms-go-apihub/core"
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+	"github.com/valyala/fasthttp"
+)
+
+// apiGateway开启dryRun后，请求头或者query中带有dry-run标志时，httpApi不发出请求
+const dryRunHeader = "X-Apihub-Dry-Run"
+const dryRunQuery = "dryRun"
+const dryRunMask = "******"
+
+func isDryRunRequest(stack *hub.Stack) bool {
+	if !defaultApp.dryRun {
+		return false
+	}
+	c := stack.GinContext
+	return isFlagEnabled(c.GetHeader(dryRunHeader)) || isFlagEnabled(c.Query(dryRunQuery))
+}
+
+// 可能包含密钥的值：来自private、env和收到的请求头，或者引用了名称敏感的heap值（例如{{.auth.access_token}}）
+func isSecretValue(value *hub.BaseValueDef) bool {
+	switch value.From {
+	case "private", "env", "header":
+		return true
+	case "literal":
+		return false
+	}
+	return core.IsSecretName(value.Content)
+}
+
+func isSecretParam(param *hub.HttpApiDefParam) bool {
+	return isSecretValue(&param.Value) || core.IsSecretName(param.Name)
+}
+
+func appendSecretValue(values []string, value string) []string {
+	if len(value) == 0 {
+		return values
+	}
+	return append(values, value, url.QueryEscape(value), url.PathEscape(value))
+}
+
+// 收集可能包含密钥的参数值，dry-run结果中用掩码代替
+func getSecretValues(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub.PrivateArray) []string {
+	var values []string
+	if HttpApi.DynamicUrl != nil && isSecretValue(HttpApi.DynamicUrl) {
+		value, _ := util.GetParameterStringValue(stack, privateDef, HttpApi.DynamicUrl)
+		values = appendSecretValue(values, value)
+	}
+	if HttpApi.Args == nil {
+		return values
+	}
+
+	for i := range *HttpApi.Args {
+		param := &(*HttpApi.Args)[i]
+		if !isSecretParam(param) {
+			continue
+		}
+		value, err := util.GetParameterStringValue(stack, privateDef, &param.Value)
+		if err == nil {
+			values = appendSecretValue(values, value)
+		}
+	}
+	return values
+}
+
+// 名称敏感的header和cookie整体隐藏
+func isSecretHeader(name string) bool {
+	return core.IsSecretName(name) || strings.EqualFold(name, "Cookie")
+}
+
+// 生成要发送的请求但不发送，返回method、url、headers和body
+func dryRunHttpApi(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub.PrivateArray) (interface{}, int) {
+	outReq, code, err := createNewRequest(stack, HttpApi, privateDef)
+	if code != http.StatusOK {
+		logger.LogS().Errorln(stack.BaseString, "dry-run生成请求失败：", HttpApi.Id, err)
+		return util.ErrorResult(hub.TmsErrorApisId, code, err.Error(), err)
+	}
+
+	secrets := getSecretValues(stack, HttpApi, privateDef)
+	mask := func(value string) string {
+		for _, secret := range secrets {
+			value = strings.ReplaceAll(value, secret, dryRunMask)
+		}
+		return value
+	}
+
+	//multipart和binary中的文件内容只返回长度
+	body := string(outReq.Body())
+	if !utf8.ValidString(body) {
+		body = "二进制内容，长度：" + strconv.Itoa(len(body))
+	}
+
+	headers := make(map[string]string)
+	outReq.Header.VisitAll(func(key, value []byte) {
+		if isSecretHeader(string(key)) {
+			headers[string(key)] = dryRunMask
+		} else {
+			headers[string(key)] = mask(string(value))
+		}
+	})
+	result := map[string]interface{}{
+		"dryRun":  true,
+		"id":      HttpApi.Id,
+		"method":  string(outReq.Header.Method()),
+		"url":     mask(outReq.URI().String()),
+		"headers": headers,
+		"body":    mask(body),
+	}
+	fasthttp.ReleaseRequest(outReq)
+
+	logger.LogS().Infoln(stack.BaseString, "dry-run，不发送请求：", result)
+	return result, http.StatusOK
+}
+
+func dryRunInit() {
+	core.RegisterDryRunApis(map[string]hub.ApiHandler{
+		"storageStore":       core.DryRunStub,
+		"storageClear":       core.DryRunStub,
+		"promHttpCounterInc": core.DryRunStub,
+		"loadConf":           core.DryRunStub,
+		"downloadConf":       core.DryRunStub,
+		"decompressZip":      core.DryRunStub,
+	})
+}
@@ -0,0 +1,72 @@
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func newTestGinContext(target string, header map[string]string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		c.Request.Header.Set(k, v)
+	}
+	return c
+}
+
+func TestIsDryRunRequestNeedsGatewayOption(t *testing.T) {
+	stack := &hub.Stack{GinContext: newTestGinContext("/flow/a?dryRun=true", map[string]string{dryRunHeader: "true"})}
+	defer func() { defaultApp.dryRun = false }()
+
+	defaultApp.dryRun = false
+	if isDryRunRequest(stack) {
+		t.Fatal("dry-run enabled without the apiGateway option")
+	}
+	defaultApp.dryRun = true
+	if !isDryRunRequest(stack) {
+		t.Fatal("dry-run not enabled")
+	}
+}
+
+func TestDryRunMasksSecrets(t *testing.T) {
+	t.Setenv("TEST_DRY_RUN_KEY", "env-secret-value")
+	stack := &hub.Stack{
+		GinContext: newTestGinContext("/httpapi/a", map[string]string{"X-User-Token": "inbound-secret-value"}),
+		Context:    context.Background(),
+		Heap: hub.NewHeap(map[string]interface{}{
+			"auth": map[string]interface{}{"access_token": "heap-secret-value"},
+			"user": "alice",
+		}),
+	}
+	args := []hub.HttpApiDefParam{
+		{In: "query", Name: "key", Value: hub.BaseValueDef{From: "env", Content: "TEST_DRY_RUN_KEY"}},
+		{In: "query", Name: "user", Value: hub.BaseValueDef{From: "template", Content: "{{.user}}"}},
+		{In: "header", Name: "Authorization", Value: hub.BaseValueDef{From: "template", Content: "Bearer {{.auth.access_token}}"}},
+		{In: "header", Name: "X-Forward", Value: hub.BaseValueDef{From: "header", Content: "X-User-Token"}},
+		{In: "header", Name: "X-Session", Value: hub.BaseValueDef{From: "template", Content: "{{.auth.access_token}}"}},
+		{In: "body", Name: "sign", Value: hub.BaseValueDef{From: "literal", Content: "literal-secret-value"}},
+		{In: "body", Name: "copy", Value: hub.BaseValueDef{From: "env", Content: "TEST_DRY_RUN_KEY"}},
+	}
+	api := &hub.HttpApiDef{Id: "dry", Url: "http://upstream.example/path", Method: "POST", RequestContentType: "json", Args: &args}
+
+	result, code := dryRunHttpApi(stack, api, nil)
+	if code != http.StatusOK {
+		t.Fatalf("code = %d, result = %v", code, result)
+	}
+	data, _ := json.Marshal(result)
+	for _, secret := range []string{"env-secret-value", "inbound-secret-value", "heap-secret-value"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("%s not masked: %s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), "alice") {
+		t.Errorf("plain value masked: %s", data)
+	}
+}
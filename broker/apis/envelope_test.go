@@ -0,0 +1,85 @@
+package apis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/valyala/fasthttp"
+)
+
+func TestNewResponseEnvelope(t *testing.T) {
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	resp.SetStatusCode(http.StatusCreated)
+	resp.Header.Add("X-Tag", "a")
+	resp.Header.Add("X-Tag", "b")
+	resp.Header.Add("Set-Cookie", "sid=123; Path=/")
+	resp.SetBodyString(`{"id":1}`)
+
+	envelope := newResponseEnvelope(resp, map[string]interface{}{"id": 1})
+	if envelope["status"] != http.StatusCreated || envelope["rawBody"] != `{"id":1}` {
+		t.Fatalf("envelope = %v", envelope)
+	}
+	headers := envelope["headers"].(map[string]string)
+	if headers["X-Tag"] != "a, b" || len(headers["Set-Cookie"]) > 0 {
+		t.Fatalf("headers = %v", headers)
+	}
+	if cookies := envelope["cookies"].(map[string]string); !reflect.DeepEqual(cookies, map[string]string{"sid": "123"}) {
+		t.Fatalf("cookies = %v", cookies)
+	}
+}
+
+func TestSendRequestEnvelope(t *testing.T) {
+	resetTestPools()
+	defer resetTestPools()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		envelope bool
+		code     int
+		status   int
+		body     interface{}
+		rawBody  string
+	}{
+		{"json", "/ok", true, http.StatusOK, http.StatusOK, map[string]interface{}{"id": json.Number("1")}, `{"id":1}`},
+		{"not json and not 200", "/missing", true, http.StatusOK, http.StatusNotFound, nil, "not found\n"},
+		{"without envelope", "/missing", false, http.StatusNotFound, 0, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &hub.HttpApiDef{Id: "test", Method: "GET", Url: server.URL + tt.path, Envelope: tt.envelope}
+			result, code, _ := sendRequest(newTestHttpStack(), api, nil, false)
+			if code != tt.code {
+				t.Fatalf("code = %d, result = %v", code, result)
+			}
+			if !tt.envelope {
+				return
+			}
+			envelope, ok := result.(map[string]interface{})
+			if !ok || envelope["status"] != tt.status || !reflect.DeepEqual(envelope["body"], tt.body) || envelope["rawBody"] != tt.rawBody {
+				t.Fatalf("envelope = %v", result)
+			}
+		})
+	}
+
+	//连接失败时仍然失败
+	server.Close()
+	api := &hub.HttpApiDef{Id: "test", Method: "GET", Url: server.URL + "/ok", Envelope: true}
+	if result, code, _ := sendRequest(newTestHttpStack(), api, nil, false); code == http.StatusOK {
+		t.Fatalf("closed server: code = %d, result = %v", code, result)
+	}
+}
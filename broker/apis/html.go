@@ -57,7 +57,7 @@ func createHtml(stack *hub.Stack, params map[string]string) (interface{}, int) {
 		}
 	}
 
-	result, err := json2Html(stack.Heap, content)
+	result, err := json2Html(stack.Heap.Snapshot(), content)
 	if err != nil {
 		return util.CreateTmsError(hub.TmsErrorApisId, err.Error(), nil), http.StatusInternalServerError
 	}
@@ -1,8 +1,11 @@
 package apis
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -23,29 +26,27 @@ var jsonEx = jsoniter.Config{
 	UseNumber: true,
 }.Froze()
 
-func preHttpapis(stack *hub.Stack, name string) {
-	//	logger.LogS().Infoln("___pre HTTPAPI:", stack.BaseString, " Name:", name)
-}
-
 func postHttpapis(stack *hub.Stack, name string, result string, code int, duration float64) {
 	if stack == nil {
 		return
 	}
-	_, ok := stack.Heap[hub.HeapBaseName]
+	_, ok := stack.Heap.Get(hub.HeapBaseName)
 	if !ok {
 		return
 	}
 
-	stats := make(map[string]string)
-	stack.Heap[hub.HeapStatsName] = stats
-	defer delete(stack.Heap, hub.HeapStatsName)
+	//请求取消或者超时后，统计仍然需要执行，stats只在post流程中可见
+	stack = stack.Scope()
+	stack.Context = context.Background()
 
+	stats := make(map[string]string)
 	stats["child"] = name
 	stats["duration"] = strconv.FormatFloat(duration, 'f', 5, 64)
 	stats["code"] = strconv.FormatInt(int64(code), 10)
 	if code == http.StatusOK {
 		stats["id"] = "0"
 		stats["msg"] = "ok"
+		stack.Heap.Set(hub.HeapStatsName, stats)
 		logger.LogS().Infoln("___post HTTPAPI OK:", stack.BaseString, " name：", name, ", result:", result, " code:", code, " stats:", stats)
 		params := []hub.BaseParamDef{{Name: "name", Value: hub.BaseValueDef{From: "literal", Content: "_HTTPOK"}}}
 		core.ApiRun(stack, &hub.ApiDef{Name: "HTTPAPI_POST_OK", Command: "flowApi", Args: &params}, "", true)
@@ -57,19 +58,57 @@ func postHttpapis(stack *hub.Stack, name string, result string, code int, durati
 		} else {
 			stats["msg"] = "nok"
 		}
+		stack.Heap.Set(hub.HeapStatsName, stats)
 		logger.LogS().Errorln("!!!!post HTTPAPI NOK:", stack.BaseString, " name：", name, ", result:", result, " code:", code, " stats:", stats)
 		params := []hub.BaseParamDef{{Name: "name", Value: hub.BaseValueDef{From: "literal", Content: "_HTTPNOK"}}}
 		core.ApiRun(stack, &hub.ApiDef{Name: "HTTPAPI_POST_NOK", Command: "flowApi", Args: &params}, "", true)
 	}
 }
 
+// 用转义后的参数值替换url路径中的{name}，不修改query部分
+func replacePathParams(rawUrl string, values map[string]string) string {
+	path, query := rawUrl, ""
+	if index := strings.Index(rawUrl, "?"); index >= 0 {
+		path, query = rawUrl[:index], rawUrl[index:]
+	}
+	for name, value := range values {
+		path = strings.ReplaceAll(path, "{"+name+"}", url.PathEscape(value))
+	}
+	return path + query
+}
+
 func createNewRequest(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub.PrivateArray) (*fasthttp.Request, int, error) {
+	method := strings.ToUpper(HttpApi.Method)
+	if !util.IsHttpMethodSupported(method) {
+		str := "不支持的method：" + HttpApi.Method
+		logger.LogS().Errorln(stack.BaseString, str)
+		return nil, http.StatusInternalServerError, errors.New(str)
+	}
+	// 要发送的请求，失败时释放
+	outReq := fasthttp.AcquireRequest()
+	code, err := fillNewRequest(stack, HttpApi, privateDef, method, outReq)
+	if err != nil || code != http.StatusOK {
+		fasthttp.ReleaseRequest(outReq)
+		return nil, code, err
+	}
+	return outReq, http.StatusOK, nil
+}
+
+func urlError(stack *hub.Stack, code int, err error) (int, error) {
+	str := "无效的url：" + err.Error()
+	logger.LogS().Errorln(stack.BaseString, str)
+	return code, util.NewStatusError(hub.TmsErrorUrlId, code, str, err)
+}
+
+// 按照HTTPAPI定义设置请求的method、url、header和body
+func fillNewRequest(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub.PrivateArray, method string, outReq *fasthttp.Request) (int, error) {
 	var outBody string
+	var outBinary []byte
 	var hasBody bool
 	var err error
-	// 要发送的请求
-	outReq := fasthttp.AcquireRequest()
-	outReq.Header.SetMethod(HttpApi.Method)
+	var parts *multipart.Writer
+	var partsBuf bytes.Buffer
+	outReq.Header.SetMethod(method)
 	hasBody = len(HttpApi.RequestContentType) > 0 && HttpApi.RequestContentType != "none"
 	if hasBody {
 		switch HttpApi.RequestContentType {
@@ -77,11 +116,18 @@ func createNewRequest(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub
 			outReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		case "json":
 			outReq.Header.Set("Content-Type", "application/json")
+		case "multipart":
+			parts = multipart.NewWriter(&partsBuf)
+			outReq.Header.Set("Content-Type", parts.FormDataContentType())
+		case "binary":
+			outReq.Header.Set("Content-Type", defaultFileContentType)
 		case hub.HeapOriginName:
-			contentType := stack.GinContext.Request.Header.Get("Content-Type")
-			outReq.Header.Set("Content-Type", contentType)
+			if stack.GinContext != nil {
+				outReq.Header.Set("Content-Type", stack.GinContext.Request.Header.Get("Content-Type"))
+			}
 			// 收到的请求中的数据
-			inData, _ := json.Marshal(stack.Heap[hub.HeapOriginName])
+			origin, _ := stack.Heap.Get(hub.HeapOriginName)
+			inData, _ := json.Marshal(origin)
 			outBody = string(inData)
 		default:
 			outReq.Header.Set("Content-Type", HttpApi.RequestContentType)
@@ -95,49 +141,79 @@ func createNewRequest(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub
 		if HttpApi.DynamicUrl != nil {
 			finalUrl, err = util.GetParameterStringValue(stack, privateDef, HttpApi.DynamicUrl)
 			if err != nil {
-				return nil, http.StatusForbidden, err
+				return http.StatusForbidden, err
 			}
 		} else {
 			str := "无有效url：" + stack.BaseString
 			logger.LogS().Errorln(str)
-			return nil, http.StatusForbidden, errors.New(str)
+			return http.StatusForbidden, errors.New(str)
 		}
 	} else {
 		finalUrl = HttpApi.Url
 	}
-	outReqURL, _ := url.Parse(finalUrl)
+	outReqURL, err := url.Parse(finalUrl)
+	if err != nil {
+		//dynamicUrl可能来自收到的请求
+		if len(HttpApi.Url) == 0 {
+			return urlError(stack, http.StatusBadRequest, err)
+		}
+		return urlError(stack, http.StatusInternalServerError, err)
+	}
 	// 设置请求参数
 	outReqParamRules := HttpApi.Args
 	if outReqParamRules != nil {
 		paramLen := len(*outReqParamRules)
 		if paramLen > 0 {
 			var value string
+			var pathValues map[string]string
 			q := outReqURL.Query()
-			vars := make(map[string]string, paramLen)
-			stack.Heap[hub.HeapVarsName] = vars
-			defer delete(stack.Heap, hub.HeapVarsName)
+			//vars只在计算参数时可见
+			stack = stack.Scope()
+			stack.Heap.Set(hub.HeapVarsName, make(map[string]string, paramLen))
 
 			for _, param := range *outReqParamRules {
 				if len(param.Name) > 0 {
 					value, err = util.GetParameterStringValue(stack, privateDef, &param.Value)
 					if err != nil {
-						return nil, http.StatusForbidden, err
+						return http.StatusForbidden, err
 					}
 
 					switch param.In {
 					case "query":
 						q.Set(param.Name, value)
+					case "path":
+						if len(value) == 0 {
+							str := "获得path参数失败：" + param.Name
+							logger.LogS().Errorln(stack.BaseString, str)
+							return http.StatusBadRequest, util.NewStatusError(hub.TmsErrorPathId, http.StatusBadRequest, str, nil)
+						}
+						if pathValues == nil {
+							pathValues = make(map[string]string)
+						}
+						pathValues[param.Name] = value
 					case "header":
 						outReq.Header.Set(param.Name, value)
 					case "body":
 						if hasBody && HttpApi.RequestContentType != hub.HeapOriginName {
 							if HttpApi.RequestContentType == "form" {
 								args.Set(param.Name, value)
+							} else if HttpApi.RequestContentType == "multipart" {
+								if err = writeMultipartField(stack, parts, &param, value); err != nil {
+									return http.StatusBadRequest, err
+								}
+							} else if HttpApi.RequestContentType == "binary" && param.File != nil {
+								content, err := getFileContent(stack, &param, value)
+								if err != nil {
+									return http.StatusBadRequest, err
+								}
+								outBinary = content.data
+								outReq.Header.Set("Content-Type", content.contentType)
 							} else {
 								if len(outBody) == 0 {
 									if value == "null" {
-										logger.LogS().Errorln("获得body失败：", stack.BaseString)
-										panic("获得body失败：")
+										str := "获得body失败：" + param.Name
+										logger.LogS().Errorln(stack.BaseString, str)
+										return http.StatusBadRequest, util.NewStatusError(hub.TmsErrorBodyId, http.StatusBadRequest, str, nil)
 									} else {
 										outBody = value
 										logger.LogS().Infoln("Set body :\r\n", outBody, "\r\n", len(outBody))
@@ -153,91 +229,226 @@ func createNewRequest(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub
 					default:
 						logger.LogS().Infoln("Invalid in:", param.In, "名字", param.Name, "值", value)
 					}
-					vars[param.Name] = value
+					hub.SetHeapMapValue(stack.Heap, hub.HeapVarsName, param.Name, value)
 					//logger.LogS().Infoln("设置入参，位置", param.In, "名字", param.Name, "值", value)
 				}
 			}
+			if len(pathValues) > 0 {
+				outReqURL, err = url.Parse(replacePathParams(finalUrl, pathValues))
+				if err != nil {
+					return urlError(stack, http.StatusBadRequest, err)
+				}
+			}
 			outReqURL.RawQuery = q.Encode()
 		}
 	}
 	outReq.SetRequestURI(outReqURL.String())
+	outReq.URI().DisablePathNormalizing = true
 
-	// 处理要发送的消息体
-	if HttpApi.Method == "POST" {
+	// 处理要发送的消息体，GET、HEAD和OPTIONS不发送
+	if util.IsHttpMethodWithBody(method) {
 		if HttpApi.RequestContentType != "none" {
-			if HttpApi.RequestContentType == "form" {
+			switch HttpApi.RequestContentType {
+			case "form":
 				args.WriteTo(outReq.BodyWriter())
-			} else {
-				outReq.SetBodyString(outBody)
+			case "multipart":
+				parts.Close()
+				outReq.SetBody(partsBuf.Bytes())
+			default:
+				if outBinary != nil {
+					outReq.SetBody(outBinary)
+				} else {
+					outReq.SetBodyString(outBody)
+				}
 			}
 		}
 	}
 
-	return outReq, http.StatusOK, nil
+	return http.StatusOK, nil
 }
 
-func sendRequest(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub.PrivateArray, internal bool) (interface{}, int, error) {
-	var jsonInRspBody interface{}
-	var code int
+// 发出请求，context取消或者超时时立即返回false，此时req和resp在请求真正结束后释放
+func doRequest(ctx context.Context, client *fasthttp.HostClient, req *fasthttp.Request, resp *fasthttp.Response) (bool, error) {
+	if ctx.Done() == nil {
+		return true, client.Do(req, resp)
+	}
+	if err := ctx.Err(); err != nil {
+		return true, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if deadline, ok := ctx.Deadline(); ok {
+			done <- client.DoDeadline(req, resp, deadline)
+		} else {
+			done <- client.Do(req, resp)
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return true, err
+	case <-ctx.Done():
+		go func() {
+			<-done
+			fasthttp.ReleaseRequest(req)
+			fasthttp.ReleaseResponse(resp)
+		}()
+		return false, ctx.Err()
+	}
+}
 
+func sendRequest(stack *hub.Stack, HttpApi *hub.HttpApiDef, privateDef *hub.PrivateArray, internal bool) (interface{}, int, error) {
 	outReq, code, e := createNewRequest(stack, HttpApi, privateDef)
 	if code != fasthttp.StatusOK {
 		return nil, fasthttp.StatusInternalServerError, e
 	}
-	defer fasthttp.ReleaseRequest(outReq)
+	completed := true
+	defer func() {
+		if completed {
+			fasthttp.ReleaseRequest(outReq)
+		}
+	}()
+
+	call := &hub.HttpCall{Api: HttpApi, Request: outReq, Internal: internal}
+	result, code, err := core.RunHttpMiddlewares(stack, call, func(stack *hub.Stack, call *hub.HttpCall) (result interface{}, code int, err error) {
+		result, code, completed, err = doHttpCall(stack, call)
+		return
+	})
+	if code != fasthttp.StatusOK {
+		//envelope时只要收到了回复就作为结果返回，由flow根据status处理
+		if envelope, ok := result.(map[string]interface{}); ok && HttpApi.Envelope {
+			return envelope, fasthttp.StatusOK, nil
+		}
+		return nil, code, err
+	}
+	return result, fasthttp.StatusOK, nil
+}
+
+// 回复的状态码、header、cookie、解析后的body和原始body，body不是JSON时为nil
+func newResponseEnvelope(resp *fasthttp.Response, body interface{}) map[string]interface{} {
+	headers := make(map[string]string)
+	resp.Header.VisitAll(func(key, value []byte) {
+		name := string(key)
+		if name == fasthttp.HeaderSetCookie {
+			return
+		}
+		if old, ok := headers[name]; ok {
+			headers[name] = old + ", " + string(value)
+		} else {
+			headers[name] = string(value)
+		}
+	})
+
+	cookies := make(map[string]string)
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		cookie := fasthttp.AcquireCookie()
+		if cookie.ParseBytes(value) == nil {
+			cookies[string(key)] = string(cookie.Value())
+		}
+		fasthttp.ReleaseCookie(cookie)
+	})
+
+	return map[string]interface{}{
+		"status":  resp.StatusCode(),
+		"headers": headers,
+		"cookies": cookies,
+		"body":    body,
+		"rawBody": string(resp.Body()),
+	}
+}
+
+// 发出请求并解析回复，状态码不是200时返回回复的原始内容（envelope时为回复的envelope），请求被取消时completed为false，req在请求真正结束后释放
+func doHttpCall(stack *hub.Stack, call *hub.HttpCall) (result interface{}, code int, completed bool, err error) {
+	HttpApi := call.Api
+	pool, err := getHttpClientPool(HttpApi.Client, call.Request)
+	if err != nil {
+		return nil, fasthttp.StatusInternalServerError, true, err
+	}
 	// 发出请求
-	client := &fasthttp.Client{}
 	resp := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(resp)
-	var t time.Time
-	if !internal {
-		preHttpapis(stack, HttpApi.Id)
-		t = time.Now()
-	}
-	err := client.Do(outReq, resp)
-	var duration float64
-	if !internal {
-		duration = time.Since(t).Seconds()
+	completed, err = doRequest(util.GetContext(stack), pool.client, call.Request, resp)
+	pool.record(err)
+	if completed {
+		defer fasthttp.ReleaseResponse(resp)
 	}
 	if err != nil {
-		logger.LogS().Errorln("ERR Connection error: ", err)
-		if !internal {
-			postHttpapis(stack, HttpApi.Id, err.Error(), 500, duration)
+		code = fasthttp.StatusInternalServerError
+		if util.GetContext(stack).Err() != nil || errors.Is(err, fasthttp.ErrTimeout) {
+			code = fasthttp.StatusGatewayTimeout
 		}
-		return nil, fasthttp.StatusInternalServerError, err
+		logger.LogS().Errorln("ERR Connection error: ", err)
+		return nil, code, completed, err
 	}
 
 	returnBody := resp.Body()
 	code = resp.StatusCode()
+	// 将收到的结果转为JSON对象
+	var jsonInRspBody interface{}
+	if len(returnBody) > 0 {
+		if err := jsonEx.Unmarshal(returnBody, &jsonInRspBody); err != nil {
+			logger.LogS().Warnln(stack.BaseString, "回复不是JSON：", HttpApi.Id, " code:", code, " err:", err)
+			jsonInRspBody = nil
+		}
+	}
+
+	var envelope map[string]interface{}
+	if HttpApi.Envelope {
+		envelope = newResponseEnvelope(resp, jsonInRspBody)
+	}
+
 	if code != fasthttp.StatusOK {
 		str := "错误JSON: " + string(returnBody)
 		logger.LogS().Errorln(str)
-		if !internal {
-			postHttpapis(stack, HttpApi.Id, string(returnBody), code, duration)
+		if envelope != nil {
+			return envelope, code, completed, errors.New("返回错误JSON")
 		}
-		return nil, code, errors.New("返回错误JSON")
+		return string(returnBody), code, completed, errors.New("返回错误JSON")
 	}
 
-	if !internal {
-		postHttpapis(stack, HttpApi.Id, "", code, duration)
+	result = jsonInRspBody
+	if envelope != nil {
+		result = envelope
 	}
-	// 将收到的结果转为JSON对象
-	jsonEx.Unmarshal(returnBody, &jsonInRspBody)
 
 	if HttpApi.Cache != nil {
 		//解析过期时间，如果存在则记录下来
-		stack.Heap[hub.HeapResultName] = jsonInRspBody
-		defer delete(stack.Heap, hub.HeapResultName)
-		expires, ok := handleExpireTime(stack, HttpApi, resp)
+		scope := stack.Scope()
+		scope.Heap.Set(hub.HeapResultName, jsonInRspBody)
+		expires, ok := handleExpireTime(scope, HttpApi, resp)
 		if !ok {
 			logger.LogS().Warnln("没有查询到过期时间")
 		} else {
 			HttpApi.Cache.Expires = expires
-			HttpApi.Cache.Resp = jsonInRspBody
+			HttpApi.Cache.Resp = result
 		}
 	}
 
-	return jsonInRspBody, fasthttp.StatusOK, nil
+	return result, fasthttp.StatusOK, completed, nil
+}
+
+// 内置的统计中间件，请求结束后执行_HTTPOK或_HTTPNOK流程
+func httpStatsMiddleware(stack *hub.Stack, call *hub.HttpCall, next hub.HttpCallHandler) (interface{}, int, error) {
+	if call.Internal {
+		return next(stack, call)
+	}
+
+	t := time.Now()
+	result, code, err := next(stack, call)
+	var msg string
+	if code != fasthttp.StatusOK {
+		switch body := result.(type) {
+		case string:
+			msg = body
+		case map[string]interface{}:
+			msg, _ = body["rawBody"].(string)
+		}
+		if len(msg) == 0 && err != nil {
+			msg = err.Error()
+		}
+	}
+	postHttpapis(stack, call.Api.Id, msg, code, time.Since(t).Seconds())
+	return result, code, err
 }
 
 func handleExpireTime(stack *hub.Stack, HttpApi *hub.HttpApiDef, resp *fasthttp.Response) (time.Time, bool) {
@@ -389,6 +600,10 @@ func run(stack *hub.Stack, name string, private string, internal bool) (jsonOutR
 			return util.CreateTmsError(hub.TmsErrorApisId, str, nil), http.StatusForbidden
 		}
 	}
+	if stack.DryRun {
+		return dryRunHttpApi(stack, HttpApi, privateDef)
+	}
+
 	var err error
 	if HttpApi.Cache != nil { //如果Json文件中配置了cache，表示支持缓存
 		if jsonOutRspBody = getCacheContentWithLock(HttpApi); jsonOutRspBody == nil {
@@ -412,7 +627,11 @@ func run(stack *hub.Stack, name string, private string, internal bool) (jsonOutR
 
 	if code != fasthttp.StatusOK {
 		logger.LogS().Errorln(stack.BaseString, "处理", HttpApi.Url, "失败.", "code：", code)
-		return util.CreateTmsError(hub.TmsErrorApisId, err.Error(), nil), code
+		str := "处理" + HttpApi.Id + "失败"
+		if err != nil {
+			str = err.Error()
+		}
+		return util.ErrorResult(hub.TmsErrorApisId, code, str, err)
 	}
 	logger.LogS().Infoln(stack.BaseString, "处理", HttpApi.Url, "成功.")
 	return jsonOutRspBody, fasthttp.StatusOK
@@ -453,7 +672,7 @@ func httpResponse(stack *hub.Stack, params map[string]string) (interface{}, int)
 		code, _ = strconv.Atoi(codeStr)
 	}
 
-	result := stack.Heap[key]
+	result, _ := stack.Heap.Get(key)
 	if result == nil {
 		str := "缺少httpapi result"
 		logger.LogS().Errorln(stack.BaseString, str)
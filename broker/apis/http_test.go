@@ -0,0 +1,112 @@
+package apis
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func newTestHttpStack() *hub.Stack {
+	return &hub.Stack{Context: context.Background(), Heap: hub.NewHeap(nil)}
+}
+
+func TestCreateNewRequestInvalidUrl(t *testing.T) {
+	query := []hub.HttpApiDefParam{{In: "query", Name: "a", Value: hub.BaseValueDef{From: "literal", Content: "1"}}}
+	tests := []struct {
+		name string
+		api  hub.HttpApiDef
+		code int
+	}{
+		{"url", hub.HttpApiDef{Url: "http://[::1", Args: &query}, http.StatusInternalServerError},
+		{"dynamicUrl", hub.HttpApiDef{DynamicUrl: &hub.BaseValueDef{From: "literal", Content: "http://a b/%zz"}, Args: &query}, http.StatusBadRequest},
+		{"no args", hub.HttpApiDef{Url: "http://[::1"}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.api.Id, tt.api.Method = "test", "GET"
+			outReq, code, err := createNewRequest(newTestHttpStack(), &tt.api, nil)
+			var statusErr *hub.StatusError
+			if outReq != nil || code != tt.code || !errors.As(err, &statusErr) || statusErr.Id != hub.TmsErrorUrlId {
+				t.Fatalf("code = %d, err = %v", code, err)
+			}
+		})
+	}
+}
+
+func TestReplacePathParams(t *testing.T) {
+	tests := []struct {
+		url    string
+		values map[string]string
+		want   string
+	}{
+		{"http://a.example/users/{id}/orders", map[string]string{"id": "42"}, "http://a.example/users/42/orders"},
+		{"http://a.example/users/{id}", map[string]string{"id": "a b/c?d#e"}, "http://a.example/users/a%20b%2Fc%3Fd%23e"},
+		{"http://a.example/{a}/{b}/{a}", map[string]string{"a": "x", "b": "中文"}, "http://a.example/x/%E4%B8%AD%E6%96%87/x"},
+		{"http://a.example/{id}?q={id}", map[string]string{"id": "1"}, "http://a.example/1?q={id}"},
+	}
+	for _, tt := range tests {
+		if got := replacePathParams(tt.url, tt.values); got != tt.want {
+			t.Errorf("replacePathParams(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestCreateNewRequestPathParams(t *testing.T) {
+	args := func(id string) *[]hub.HttpApiDefParam {
+		return &[]hub.HttpApiDefParam{
+			{In: "path", Name: "id", Value: hub.BaseValueDef{From: "literal", Content: id}},
+			{In: "query", Name: "q", Value: hub.BaseValueDef{From: "literal", Content: "1"}},
+		}
+	}
+	api := &hub.HttpApiDef{Id: "test", Method: "GET", Url: "http://a.example/users/{id}/orders", Args: args("a/b c")}
+	outReq, code, err := createNewRequest(newTestHttpStack(), api, nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("code = %d, err = %v", code, err)
+	}
+	//转义的/原样发送
+	if got := outReq.URI().String(); got != "http://a.example/users/a%2Fb%20c/orders?q=1" {
+		t.Fatalf("uri = %s", got)
+	}
+
+	api.Args = args("")
+	outReq, code, err = createNewRequest(newTestHttpStack(), api, nil)
+	var statusErr *hub.StatusError
+	if outReq != nil || code != http.StatusBadRequest || !errors.As(err, &statusErr) || statusErr.Id != hub.TmsErrorPathId {
+		t.Fatalf("empty path value: code = %d, err = %v", code, err)
+	}
+}
+
+func TestCreateNewRequestBodyMethods(t *testing.T) {
+	body := []hub.HttpApiDefParam{{In: "body", Name: "data", Value: hub.BaseValueDef{From: "literal", Content: `{"a":1}`}}}
+	tests := []struct {
+		method string
+		body   string
+	}{
+		{"post", `{"a":1}`},
+		{"PUT", `{"a":1}`},
+		{"patch", `{"a":1}`},
+		{"DELETE", `{"a":1}`},
+		{"GET", ""},
+		{"HEAD", ""},
+		{"OPTIONS", ""},
+	}
+	for _, tt := range tests {
+		api := &hub.HttpApiDef{Id: "test", Method: tt.method, Url: "http://a.example/x", RequestContentType: "json", Args: &body}
+		outReq, code, err := createNewRequest(newTestHttpStack(), api, nil)
+		if err != nil || code != http.StatusOK {
+			t.Fatalf("method %s: code = %d, err = %v", tt.method, code, err)
+		}
+		if string(outReq.Header.Method()) != strings.ToUpper(tt.method) || string(outReq.Body()) != tt.body {
+			t.Errorf("method %s: sent %s with body %q", tt.method, outReq.Header.Method(), outReq.Body())
+		}
+	}
+
+	api := &hub.HttpApiDef{Id: "test", Method: "TRACE", Url: "http://a.example/x"}
+	if outReq, code, err := createNewRequest(newTestHttpStack(), api, nil); outReq != nil || code != http.StatusInternalServerError || err == nil {
+		t.Fatalf("TRACE: code = %d, err = %v", code, err)
+	}
+}
@@ -21,8 +21,10 @@ func createJson(stack *hub.Stack, params map[string]string) (interface{}, int) {
 		logger.LogS().Errorln(stack.BaseString, str)
 		return util.CreateTmsError(hub.TmsErrorApisId, str, nil), http.StatusInternalServerError
 	}
-	tmp := stack.Heap[hub.HeapOriginName].(map[string]interface{})
+	tmp := hub.GetHeapMap[interface{}](stack.Heap, hub.HeapOriginName)
 	result := tmp[key]
-	delete(tmp, key)
+	if tmp != nil {
+		hub.DeleteHeapMapValue[interface{}](stack.Heap, hub.HeapOriginName, key)
+	}
 	return result, http.StatusOK
 }
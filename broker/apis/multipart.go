@@ -0,0 +1,197 @@
+package apis
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultFileContentType = "application/octet-stream"
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+type fileContent struct {
+	data        []byte
+	fileName    string
+	contentType string
+}
+
+func fileError(stack *hub.Stack, code int, str string, err error) error {
+	logger.LogS().Errorln(stack.BaseString, str, err)
+	return util.NewStatusError(hub.TmsErrorBodyId, code, str, err)
+}
+
+// 支持data:image/png;base64,xxx格式，其中的类型作为默认的contentType
+func decodeBase64File(stack *hub.Stack, name string, value string) (*fileContent, error) {
+	content := &fileContent{}
+	if strings.HasPrefix(value, "data:") {
+		index := strings.Index(value, ",")
+		if index < 0 {
+			return nil, fileError(stack, http.StatusBadRequest, "无效的base64内容："+name, nil)
+		}
+		content.contentType = strings.TrimSuffix(value[len("data:"):index], ";base64")
+		value = value[index+1:]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fileError(stack, http.StatusBadRequest, "无效的base64内容："+name, err)
+	}
+	content.data = data
+	return content, nil
+}
+
+// 没有指定root时，本地文件在配置根目录下的files目录中
+const defaultFileRoot = "files"
+
+// 获得root目录下的文件路径，路径来自请求时，不能通过绝对路径、..或者符号链接访问root以外的文件
+func resolveLocalFile(root string, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return "", errors.New("不能使用绝对路径")
+	}
+	rel := filepath.Clean(path)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("路径超出了根目录")
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(filepath.Join(realRoot, rel))
+	if err != nil {
+		return "", err
+	}
+	if inside, err := filepath.Rel(realRoot, realPath); err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", errors.New("路径超出了根目录")
+	}
+	return realPath, nil
+}
+
+// root为相对路径时从配置的根目录开始
+func getFileRoot(file *hub.HttpFileDef) string {
+	root := file.Root
+	if len(root) == 0 {
+		root = defaultFileRoot
+	}
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(util.GetBasePath(), root)
+	}
+	return root
+}
+
+func readLocalFile(stack *hub.Stack, param *hub.HttpApiDefParam, path string) (*fileContent, error) {
+	path, err := resolveLocalFile(getFileRoot(param.File), path)
+	if err != nil {
+		return nil, fileError(stack, http.StatusBadRequest, "无效的文件路径："+param.Name, err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fileError(stack, http.StatusInternalServerError, "读取文件失败："+param.Name, err)
+	}
+	return &fileContent{data: data, fileName: filepath.Base(path)}, nil
+}
+
+// 读取收到的multipart请求中上传的文件
+func readUploadFile(stack *hub.Stack, name string, field string) (*fileContent, error) {
+	if stack.GinContext == nil {
+		return nil, fileError(stack, http.StatusBadRequest, "没有收到的请求，无法获得上传文件："+name, nil)
+	}
+	header, err := stack.GinContext.FormFile(field)
+	if err != nil {
+		return nil, fileError(stack, http.StatusBadRequest, "获得上传文件失败："+field, err)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, fileError(stack, http.StatusBadRequest, "获得上传文件失败："+field, err)
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, fileError(stack, http.StatusBadRequest, "获得上传文件失败："+field, err)
+	}
+	return &fileContent{data: data, fileName: header.Filename, contentType: header.Header.Get("Content-Type")}, nil
+}
+
+// 收到的multipart请求中的表单字段，同名字段只取第一个
+func getMultipartValues(c *gin.Context) interface{} {
+	form, err := c.MultipartForm()
+	if err != nil {
+		logger.LogS().Errorln("解析multipart请求失败：", err)
+		return nil
+	}
+
+	values := make(map[string]interface{}, len(form.Value))
+	for k, v := range form.Value {
+		if len(v) > 0 {
+			values[k] = v[0]
+		}
+	}
+	return values
+}
+
+// 按照file定义获得文件内容，fileName和contentType以定义中的为准
+func getFileContent(stack *hub.Stack, param *hub.HttpApiDefParam, value string) (*fileContent, error) {
+	var content *fileContent
+	var err error
+	switch param.File.Source {
+	case "base64":
+		content, err = decodeBase64File(stack, param.Name, value)
+	case "file":
+		content, err = readLocalFile(stack, param, value)
+	case "upload":
+		content, err = readUploadFile(stack, param.Name, value)
+	default:
+		err = fileError(stack, http.StatusInternalServerError, "不支持的file source："+param.File.Source, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(param.File.FileName) > 0 {
+		content.fileName = param.File.FileName
+	}
+	if len(content.fileName) == 0 {
+		content.fileName = param.Name
+	}
+	if len(param.File.ContentType) > 0 {
+		content.contentType = param.File.ContentType
+	}
+	if len(content.contentType) == 0 {
+		content.contentType = defaultFileContentType
+	}
+	return content, nil
+}
+
+// 定义了file的参数作为文件部分，其他参数作为普通字段
+func writeMultipartField(stack *hub.Stack, writer *multipart.Writer, param *hub.HttpApiDefParam, value string) error {
+	if param.File == nil {
+		return writer.WriteField(param.Name, value)
+	}
+
+	content, err := getFileContent(stack, param, value)
+	if err != nil {
+		return err
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="`+quoteEscaper.Replace(param.Name)+`"; filename="`+quoteEscaper.Replace(content.fileName)+`"`)
+	header.Set("Content-Type", content.contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(content.data)
+	return err
+}
@@ -0,0 +1,46 @@
+package apis
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveLocalFile(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "files")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join(root, "a.txt"), filepath.Join(root, "sub", "b.txt"), filepath.Join(dir, "secret.txt")} {
+		if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"a.txt", true},
+		{"sub/b.txt", true},
+		{"sub/../a.txt", true},
+		{"./sub/b.txt", true},
+		{"../secret.txt", false},
+		{"sub/../../secret.txt", false},
+		{"..", false},
+		{filepath.Join(dir, "secret.txt"), false},
+		{"/etc/passwd", false},
+		{"link.txt", false},
+		{"missing.txt", false},
+	}
+	for _, tt := range tests {
+		path, err := resolveLocalFile(root, tt.path)
+		if (err == nil) != tt.ok {
+			t.Errorf("resolveLocalFile(%q) = %q, %v", tt.path, path, err)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 
+	"github.com/jasony62/tms-go-apihub/core"
 	"github.com/jasony62/tms-go-apihub/hub"
 	"github.com/jasony62/tms-go-apihub/logger"
 	"github.com/jasony62/tms-go-apihub/util"
@@ -104,4 +106,90 @@ func promInitData() {
 	)
 	prometheus.MustRegister(httpInDurationPromHistogram)
 	prometheus.MustRegister(httpOutDurationPromHistogram)
+	prometheus.MustRegister(newHttpClientCollector())
+	prometheus.MustRegister(newTriggerCollector())
+}
+
+// 采集时读取所有上游连接池的统计信息
+type httpClientCollector struct {
+	conns    *prometheus.Desc
+	pending  *prometheus.Desc
+	requests *prometheus.Desc
+	errors   *prometheus.Desc
+	timeouts *prometheus.Desc
+}
+
+func newHttpClientCollector() *httpClientCollector {
+	labels := []string{"client", "addr"}
+	return &httpClientCollector{
+		conns:    prometheus.NewDesc("http_out_client_conns", "apihub upstream open connections.", labels, nil),
+		pending:  prometheus.NewDesc("http_out_client_pending", "apihub upstream pending requests.", labels, nil),
+		requests: prometheus.NewDesc("http_out_client_requests_total", "apihub upstream requests.", labels, nil),
+		errors:   prometheus.NewDesc("http_out_client_errors_total", "apihub upstream connection errors.", labels, nil),
+		timeouts: prometheus.NewDesc("http_out_client_timeouts_total", "apihub upstream timeouts.", labels, nil),
+	}
+}
+
+func (c *httpClientCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.conns
+	ch <- c.pending
+	ch <- c.requests
+	ch <- c.errors
+	ch <- c.timeouts
+}
+
+func (c *httpClientCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, pool := range getHttpClientPools() {
+		ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(pool.client.ConnsCount()), pool.name, pool.addr)
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(pool.client.PendingRequests()), pool.name, pool.addr)
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(atomic.LoadUint64(&pool.requests)), pool.name, pool.addr)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(atomic.LoadUint64(&pool.errors)), pool.name, pool.addr)
+		ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(atomic.LoadUint64(&pool.timeouts)), pool.name, pool.addr)
+	}
+}
+
+// 采集时读取所有trigger的运行统计，重新加载trigger后计数从0开始
+type triggerCollector struct {
+	enabled      *prometheus.Desc
+	running      *prometheus.Desc
+	runs         *prometheus.Desc
+	failures     *prometheus.Desc
+	skipped      *prometheus.Desc
+	lastDuration *prometheus.Desc
+}
+
+func newTriggerCollector() *triggerCollector {
+	labels := []string{"trigger", "schedule"}
+	return &triggerCollector{
+		enabled:      prometheus.NewDesc("trigger_enabled", "apihub trigger enabled.", labels, nil),
+		running:      prometheus.NewDesc("trigger_running", "apihub trigger running schedules.", labels, nil),
+		runs:         prometheus.NewDesc("trigger_runs_total", "apihub trigger finished runs.", labels, nil),
+		failures:     prometheus.NewDesc("trigger_failures_total", "apihub trigger failed runs.", labels, nil),
+		skipped:      prometheus.NewDesc("trigger_skipped_total", "apihub trigger skipped runs.", labels, nil),
+		lastDuration: prometheus.NewDesc("trigger_last_duration_second", "apihub trigger last run duration in second.", labels, nil),
+	}
+}
+
+func (c *triggerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.enabled
+	ch <- c.running
+	ch <- c.runs
+	ch <- c.failures
+	ch <- c.skipped
+	ch <- c.lastDuration
+}
+
+func (c *triggerCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range core.GetTriggerStats() {
+		enabled := 0.0
+		if stats.Enabled {
+			enabled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.enabled, prometheus.GaugeValue, enabled, stats.Name, stats.Schedule)
+		ch <- prometheus.MustNewConstMetric(c.running, prometheus.GaugeValue, float64(stats.Running), stats.Name, stats.Schedule)
+		ch <- prometheus.MustNewConstMetric(c.runs, prometheus.CounterValue, float64(stats.Runs), stats.Name, stats.Schedule)
+		ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, float64(stats.Failures), stats.Name, stats.Schedule)
+		ch <- prometheus.MustNewConstMetric(c.skipped, prometheus.CounterValue, float64(stats.Skipped), stats.Name, stats.Schedule)
+		ch <- prometheus.MustNewConstMetric(c.lastDuration, prometheus.GaugeValue, stats.LastDuration, stats.Name, stats.Schedule)
+	}
 }
@@ -0,0 +1,117 @@
+package apis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/util"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func loadTestSchema(t *testing.T, name string) *gojsonschema.Schema {
+	t.Helper()
+	content, err := os.ReadFile("../../schema/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(string(content)))
+	if err != nil {
+		t.Fatal(name, err)
+	}
+	return schema
+}
+
+func checkTestSchema(t *testing.T, names []string, doc string, valid bool) {
+	t.Helper()
+	for _, name := range names {
+		result, err := loadTestSchema(t, name).Validate(gojsonschema.NewStringLoader(doc))
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if result.Valid() != valid {
+			t.Errorf("%s: valid = %v, errors = %v, doc = %s", name, result.Valid(), result.Errors(), doc)
+		}
+	}
+}
+
+func TestExampleConfMatchesSchema(t *testing.T) {
+	base := util.DefaultConfMap.BasePath
+	defer func() { util.DefaultConfMap.BasePath = base }()
+	util.DefaultConfMap.BasePath = "../../example/"
+
+	if result, code := loadSchemaDefData("../../schema"); code != 200 {
+		t.Fatalf("result = %v", result)
+	}
+}
+
+func TestFlowSchema(t *testing.T) {
+	flows := []string{"flow.json", "flow-simple.json"}
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"name": "f", "steps": [{"name": "a", "command": "httpApi"}]}`, true},
+		{`{"name": "f", "timeout": 1000, "scope": "isolated",
+			"inputs": [{"name": "city", "type": "string", "required": true}],
+			"outputs": [{"name": "weather", "value": {"from": "heap", "content": "weather"}}],
+			"steps": [{"name": "a", "command": "httpApi", "when": "{{.origin.city}}", "timeout": 500,
+				"retry": {"maxAttempts": 3, "backoff": "exponential"},
+				"onError": [{"name": "b", "command": "httpApi"}],
+				"compensate": [{"name": "c", "command": "httpApi"}]}],
+			"onError": [{"name": "d", "command": "httpApi"}],
+			"finally": [{"name": "e", "command": "httpApi"}]}`, true},
+		{`{"name": "f", "steps": [{"name": "group", "joinMode": "failFast", "concurrentNum": 2,
+			"parallel": [{"name": "a", "command": "httpApi"}, {"name": "b", "command": "httpApi"}]}]}`, true},
+		{`{"name": "f", "steps": [{"name": "a"}]}`, false},
+		{`{"name": "f", "steps": [{"name": "a", "command": "httpApi", "parallel": [{"name": "b", "command": "httpApi"}]}]}`, false},
+		{`{"name": "f", "steps": [{"name": "group", "parallel": [{"name": "b"}]}]}`, false},
+		{`{"name": "f", "scope": "global", "steps": []}`, false},
+	}
+	for _, tt := range tests {
+		checkTestSchema(t, flows, tt.doc, tt.valid)
+	}
+}
+
+func TestScheduleSwitchCaseSchema(t *testing.T) {
+	schedules := []string{"schedule.json", "schedule-simple.json"}
+	tests := []struct {
+		cases string
+		valid bool
+	}{
+		{`[{"value": "a", "steps": []}]`, true},
+		{`[{"values": ["a", "b"], "fallthrough": true, "steps": []}]`, true},
+		{`[{"regex": "^a.*$", "steps": []}]`, true},
+		{`[{"min": 1, "max": 10, "steps": []}, {"max": 0, "steps": []}]`, true},
+		{`[{"value": "a", "steps": []}, {"default": true, "steps": []}]`, true},
+		{`[{"steps": []}]`, false},
+		{`[{"default": false, "steps": []}]`, false},
+		{`[{"value": "a", "regex": "^a", "steps": []}]`, false},
+		{`[{"values": ["a"], "min": 1, "steps": []}]`, false},
+		{`[{"value": "a", "default": true, "steps": []}]`, false},
+		{`[{"values": [], "steps": []}]`, false},
+	}
+	for _, tt := range tests {
+		doc := `{"name": "s", "steps": [{"type": "switch", "control": {"name": "c",
+			"key": {"from": "literal", "content": "a"}, "cases": ` + tt.cases + `}}]}`
+		checkTestSchema(t, schedules, doc, tt.valid)
+	}
+}
+
+func TestScheduleTaskTypeSchema(t *testing.T) {
+	tests := []struct {
+		taskType string
+		valid    bool
+	}{
+		{"api", true},
+		{"loop", true},
+		{"foreach", true},
+		{"while", true},
+		{"until", true},
+		{"switch", true},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		doc := `{"name": "s", "steps": [{"type": "` + tt.taskType + `"}]}`
+		checkTestSchema(t, []string{"schedule-simple.json"}, doc, tt.valid)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/jasony62/tms-go-apihub/hub"
 	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
 )
 
 func apiSleep(stack *hub.Stack, params map[string]string) (interface{}, int) {
@@ -26,6 +27,16 @@ func apiSleep(stack *hub.Stack, params map[string]string) (interface{}, int) {
 	hourInt, _ := strconv.Atoi(hour)
 	minuteInt, _ := strconv.Atoi(minute)
 	secondInt, _ := strconv.Atoi(second)
-	time.Sleep(time.Duration(hourInt)*time.Hour + time.Duration(minuteInt)*time.Minute + time.Duration(secondInt)*time.Second)
-	return nil, http.StatusOK
+	timer := time.NewTimer(time.Duration(hourInt)*time.Hour + time.Duration(minuteInt)*time.Minute + time.Duration(secondInt)*time.Second)
+	defer timer.Stop()
+
+	ctx := util.GetContext(stack)
+	select {
+	case <-timer.C:
+		return nil, http.StatusOK
+	case <-ctx.Done():
+		str := "sleep被取消：" + ctx.Err().Error()
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorApisId, str, ctx.Err()), http.StatusGatewayTimeout
+	}
 }
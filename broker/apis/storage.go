@@ -122,7 +122,7 @@ func storeLocal(stack *hub.Stack, user string, key string, index string, content
 	}
 
 	logger.LogS().Infoln("storeLocal: index:", index, " user:", user, " content:", content)
-	tmp := stack.Heap[hub.HeapOriginName].(map[string]interface{})
+	tmp := hub.GetHeapMap[interface{}](stack.Heap, hub.HeapOriginName)
 	result := tmp[key]
 	byteJson, err := jsonEx.Marshal(result)
 	if err != nil {
@@ -0,0 +1,149 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jasony62/tms-go-apihub/core"
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+const (
+	traceNone   = "none"
+	traceAll    = "all"
+	traceHeader = "header"
+)
+
+// 请求头中带有该header时记录trace并附加到回复中
+const traceRequestHeader = "X-Apihub-Trace"
+const traceIdHeader = "X-Apihub-Trace-Id"
+
+var flagEnableRegexp = regexp.MustCompile(`(?i)^(yes|true|1)$`)
+
+// 缓存回复内容，请求结束后和trace一起返回
+type traceWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *traceWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *traceWriter) WriteHeaderNow() {
+}
+
+func (w *traceWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *traceWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *traceWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *traceWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *traceWriter) Written() bool {
+	return w.status != 0
+}
+
+// json回复被替换为{"response":原回复,"trace":trace}，其他回复只返回trace id
+func (w *traceWriter) flush(trace json.RawMessage) {
+	body := w.body.Bytes()
+	if len(trace) > 0 && (len(body) == 0 || json.Valid(body)) {
+		if len(body) == 0 {
+			body = []byte("null")
+		}
+		wrapped, err := json.Marshal(map[string]json.RawMessage{"response": body, "trace": trace})
+		if err == nil {
+			body = wrapped
+			w.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+	}
+
+	w.ResponseWriter.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.Status())
+	w.ResponseWriter.Write(body)
+}
+
+func isFlagEnabled(value string) bool {
+	return flagEnableRegexp.MatchString(strings.TrimSpace(value))
+}
+
+// 根据apiGateway的trace配置和请求头开始记录trace，返回请求结束时调用的函数
+func traceRequest(stack *hub.Stack) func() {
+	c := stack.GinContext
+	attach := defaultApp.trace != traceNone && isFlagEnabled(c.GetHeader(traceRequestHeader))
+	if defaultApp.trace != traceAll && !attach {
+		return func() {}
+	}
+
+	base := hub.GetHeapMap[interface{}](stack.Heap, hub.HeapBaseName)
+	id := uuid.New().String()
+	hub.SetHeapMapValue[interface{}](stack.Heap, hub.HeapBaseName, "uuid", id)
+	c.Header(traceIdHeader, id)
+
+	root, _ := base["root"].(string)
+	level, _ := base["type"].(string)
+	core.StartTrace(stack, level, root)
+
+	var writer *traceWriter
+	if attach {
+		writer = &traceWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+	}
+
+	return func() {
+		//flow中可能通过fillBaseInfo修改了uuid
+		current, _ := hub.GetHeapMap[interface{}](stack.Heap, hub.HeapBaseName)["uuid"].(string)
+		core.FinishTrace(stack, c.Writer.Status(), id, current)
+		if writer != nil {
+			trace, _ := core.GetTrace(id)
+			writer.flush(trace)
+		}
+	}
+}
+
+func debugListTraces(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, core.ListTraces())
+}
+
+func debugGetTrace(c *gin.Context) {
+	trace, ok := core.GetTrace(c.Param("uuid"))
+	if !ok {
+		str := "trace不存在：" + c.Param("uuid")
+		logger.LogS().Errorln(str)
+		c.IndentedJSON(http.StatusNotFound, util.CreateTmsError(hub.TmsErrorApisId, str, nil))
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", trace)
+}
+
+// trace调试接口，只有在apiGateway配置trace时才开启
+func registerDebugRoutes(router *gin.Engine) {
+	debug := router.Group("/debug")
+	debug.GET("/traces", debugListTraces)
+	debug.GET("/traces/:uuid", debugGetTrace)
+}
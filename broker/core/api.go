@@ -1,9 +1,10 @@
 package core
 
 import (
+	"fmt"
 	"net/http"
+	"runtime/debug"
 	"sync"
-	"time"
 
 	"github.com/jasony62/tms-go-apihub/hub"
 	"github.com/jasony62/tms-go-apihub/logger"
@@ -12,6 +13,7 @@ import (
 
 var mapLock sync.Mutex
 var apiMap = make(map[string]hub.ApiHandler)
+var dryRunMap = make(map[string]hub.ApiHandler)
 
 func RegisterApis(list map[string]hub.ApiHandler) {
 	mapLock.Lock()
@@ -29,37 +31,72 @@ func RegisterApis(list map[string]hub.ApiHandler) {
 	}
 }
 
-func preApis(stack *hub.Stack, apiDef *hub.ApiDef) {
-	//	logger.LogS().Infoln("___pre API,", stack.BaseString, "command:", apiDef.Command, "name:"+apiDef.Name)
-}
+// 注册dry-run时代替原API执行的函数
+func RegisterDryRunApis(list map[string]hub.ApiHandler) {
+	mapLock.Lock()
+	defer mapLock.Unlock()
 
-func postApis(stack *hub.Stack, apiDef *hub.ApiDef, result interface{}, code int, duration float64) {
-	if stack == nil {
-		return
+	for k := range list {
+		dryRunMap[k] = list[k]
 	}
+}
 
-	if code == http.StatusOK {
-		logger.LogS().Infoln("___post API OK: ", stack.BaseString, "command:"+apiDef.Command, " name："+apiDef.Name, " result:", result, " duration(s):", duration)
-	} else {
-		logger.LogS().Errorln("!!!post API NOK:", stack.BaseString, "command :"+apiDef.Command, " name："+apiDef.Name, " result:", result, " duration(s):", duration)
-	}
+// dry-run时不执行原API，返回解析后的参数
+func DryRunStub(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	logger.LogS().Infoln(stack.BaseString, "dry-run，跳过执行 params:", params)
+	return map[string]interface{}{"dryRun": true, "args": params}, http.StatusOK
 }
 
 // task调用
 func ApiRun(stack *hub.Stack, api *hub.ApiDef, private string, internal bool) (result interface{}, ret int) {
-	var t time.Time
-	if !internal {
-		t = time.Now()
-	}
+	var attempts int
+	node, leave := enterTrace(stack, api)
+	defer func() {
+		finishTraceNode(node, result, ret, attempts)
+		leave()
+	}()
+
 	function := apiMap[api.Command]
-	var err error
 	if function == nil {
 		str := "不能执行" + api.Command
 		logger.LogS().Errorln(stack.BaseString, str)
 		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusForbidden
 	}
+	if stack.DryRun && dryRunMap[api.Command] != nil {
+		function = dryRunMap[api.Command]
+	}
+
+	var ok bool
+	if result, ret, ok = checkContext(stack, api.Name); !ok {
+		return
+	}
 
-	var origin map[string]interface{}
+	restore := withTimeout(stack, api.Timeout)
+	attempt := 0
+	result, ret, attempts = runWithRetry(stack, api.Name, api.Retry, func() (interface{}, int) {
+		attempt++
+		return apiRunOnce(stack, api, function, internal, attempt)
+	})
+	if errResult, code, ok := checkContext(stack, api.Name); !ok {
+		result, ret = errResult, code
+	}
+	restore()
+	return
+}
+
+// 将执行step时的panic转为500错误返回，1个step的错误不会导致整个服务退出
+func recoverApiPanic(stack *hub.Stack, api *hub.ApiDef, result *interface{}, ret *int) {
+	if r := recover(); r != nil {
+		str := "执行step失败：" + api.Name + " command:" + api.Command + " panic:" + fmt.Sprint(r)
+		logger.LogS().Errorln(stack.BaseString, str, "\n", string(debug.Stack()))
+		*result, *ret = util.CreateTmsError(hub.TmsErrorPanicId, str, nil), http.StatusInternalServerError
+	}
+}
+
+func apiRunOnce(stack *hub.Stack, api *hub.ApiDef, function hub.ApiHandler, internal bool, attempt int) (result interface{}, ret int) {
+	defer recoverApiPanic(stack, api, &result, &ret)
+
+	var err error
 	args := make(map[string]string)
 	var privateDef *hub.PrivateArray
 	if len(api.Private) > 0 {
@@ -80,30 +117,26 @@ func ApiRun(stack *hub.Stack, api *hub.ApiDef, private string, internal bool) (r
 			if err != nil {
 				str := "获得value失败：" + err.Error()
 				logger.LogS().Errorln(stack.BaseString, str)
-				return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
+				return util.ErrorResult(hub.TmsErrorCoreId, http.StatusInternalServerError, str, err)
 			}
 		}
 	}
 
 	if api.OriginParameters != nil {
-		origin = stack.Heap[hub.HeapOriginName].(map[string]interface{})
 		for index := range *api.OriginParameters {
 			item := (*api.OriginParameters)[index]
-			origin[item.Name], err = util.GetParameterRawValue(stack, privateDef, &item.Value)
+			value, err := util.GetParameterRawValue(stack, privateDef, &item.Value)
 			if err != nil {
 				str := "获得origin失败：" + err.Error()
 				logger.LogS().Errorln(stack.BaseString, str)
-				return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
+				return util.ErrorResult(hub.TmsErrorCoreId, http.StatusInternalServerError, str, err)
 			}
+			hub.SetHeapMapValue(stack.Heap, hub.HeapOriginName, item.Name, value)
 		}
 	}
-	if !internal {
-		preApis(stack, api)
-	}
-	result, ret = function(stack, args)
-	if !internal {
-		duration := time.Since(t).Seconds()
-		postApis(stack, api, result, ret, duration)
-	}
-	return
+
+	call := &hub.ApiCall{Api: api, Args: args, Internal: internal, Attempt: attempt}
+	return runApiMiddlewares(stack, call, func(stack *hub.Stack, call *hub.ApiCall) (interface{}, int) {
+		return function(stack, call.Args)
+	})
 }
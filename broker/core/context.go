@@ -0,0 +1,38 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+// 为stack设置超时（毫秒），返回的函数用于取消并恢复原context
+func withTimeout(stack *hub.Stack, timeout int) func() {
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	parent := stack.Context
+	ctx, cancel := context.WithTimeout(util.GetContext(stack), time.Duration(timeout)*time.Millisecond)
+	stack.Context = ctx
+	return func() {
+		cancel()
+		stack.Context = parent
+	}
+}
+
+// context被取消或者超时时返回504错误
+func checkContext(stack *hub.Stack, name string) (interface{}, int, bool) {
+	err := util.GetContext(stack).Err()
+	if err == nil {
+		return nil, http.StatusOK, true
+	}
+
+	str := "执行取消：" + name + "，" + err.Error()
+	logger.LogS().Errorln(stack.BaseString, str)
+	return util.CreateTmsError(hub.TmsErrorCoreId, str, err), http.StatusGatewayTimeout, false
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"strconv"
 	"time"
 
@@ -12,8 +13,27 @@ import (
 func init() {
 	logger.LogS().Infoln("Core register apis\n")
 	RegisterApis(map[string]hub.ApiHandler{"flowApi": runFlowApi,
-		"scheduleApi": runScheduleApi,
+		"scheduleApi":    runScheduleApi,
+		"jobList":        jobList,
+		"jobGet":         jobGet,
+		"jobCancel":      jobCancel,
+		"triggerStart":   triggerStart,
+		"triggerReload":  triggerReload,
+		"triggerList":    triggerList,
+		"triggerEnable":  triggerEnable,
+		"triggerDisable": triggerDisable,
+		"triggerRun":     triggerRun,
+		"confCheck":      confCheck,
 	})
+	RegisterDryRunApis(map[string]hub.ApiHandler{"jobCancel": DryRunStub,
+		"triggerStart":   DryRunStub,
+		"triggerReload":  DryRunStub,
+		"triggerEnable":  DryRunStub,
+		"triggerDisable": DryRunStub,
+		"triggerRun":     DryRunStub,
+	})
+	RegisterApiMiddleware("post", PostMiddlewareOrder, postMiddleware)
+	RegisterApiMiddleware("trace", TraceMiddlewareOrder, traceMiddleware)
 }
 
 func ApiHubStartMainFlow(path string) {
@@ -21,8 +41,9 @@ func ApiHubStartMainFlow(path string) {
 	var stack hub.Stack
 	stack.BaseString = " base: main. "
 	stack.StartTime = time.Now()
+	stack.Context = context.Background()
 	base := map[string]interface{}{"root": "main", "type": "flow", "start": strconv.FormatInt(time.Now().Unix(), 10)}
-	stack.Heap = map[string]interface{}{hub.HeapOriginName: map[string]interface{}{"name": "main"}, hub.HeapBaseName: base}
+	stack.Heap = hub.NewHeap(map[string]interface{}{hub.HeapOriginName: map[string]interface{}{"name": "main"}, hub.HeapBaseName: base})
 
 	ApiRun(&stack, &hub.ApiDef{Name: "main", Command: "flowApi",
 		Args: &[]hub.BaseParamDef{{Name: "name", Value: hub.BaseValueDef{From: "literal", Content: "main"}}}}, "", false)
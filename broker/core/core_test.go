@@ -0,0 +1,88 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+// 测试用的API，按照执行顺序记录name，按照code返回状态码
+var testCalls struct {
+	sync.Mutex
+	names []string
+}
+
+func init() {
+	RegisterApis(map[string]hub.ApiHandler{"testStep": testStep})
+}
+
+func testStep(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	testCalls.Lock()
+	testCalls.names = append(testCalls.names, params["name"])
+	testCalls.Unlock()
+
+	code := http.StatusOK
+	if len(params["code"]) > 0 {
+		code, _ = strconv.Atoi(params["code"])
+	}
+	return params["name"], code
+}
+
+func resetTestCalls() {
+	testCalls.Lock()
+	testCalls.names = nil
+	testCalls.Unlock()
+}
+
+func getTestCalls() []string {
+	testCalls.Lock()
+	defer testCalls.Unlock()
+	return append([]string{}, testCalls.names...)
+}
+
+func newTestStack() *hub.Stack {
+	return &hub.Stack{
+		Context: context.Background(),
+		Heap:    hub.NewHeap(map[string]interface{}{}),
+	}
+}
+
+func newTestApi(name string, code int) *hub.ApiDef {
+	args := []hub.BaseParamDef{{Name: "name", Value: hub.BaseValueDef{From: "literal", Content: name}}}
+	if code != http.StatusOK {
+		args = append(args, hub.BaseParamDef{Name: "code", Value: hub.BaseValueDef{From: "literal", Content: strconv.Itoa(code)}})
+	}
+	return &hub.ApiDef{Name: name, Command: "testStep", Args: &args}
+}
+
+func newTestTask(name string, code int) hub.ScheduleApiDef {
+	return hub.ScheduleApiDef{Type: "api", Api: newTestApi(name, code)}
+}
+
+func addTestSchedule(t *testing.T, def *hub.ScheduleDef) {
+	util.DefaultConfMap.ScheduleMap[def.Name] = def
+	t.Cleanup(func() { delete(util.DefaultConfMap.ScheduleMap, def.Name) })
+}
+
+func addTestFlow(t *testing.T, def *hub.FlowDef) {
+	util.DefaultConfMap.FlowMap[def.Name] = def
+	t.Cleanup(func() { delete(util.DefaultConfMap.FlowMap, def.Name) })
+}
+
+func checkTestCalls(t *testing.T, want ...string) {
+	t.Helper()
+	got := getTestCalls()
+	if len(got) != len(want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", got, want)
+		}
+	}
+}
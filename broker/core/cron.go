@@ -0,0 +1,162 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 标准5段cron表达式：分 时 日 月 周，支持*、*/n、a-b、a-b/n和逗号分隔的列表
+type cronSchedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+func parseCronRange(expr string, min int, max int) (int, int, error) {
+	if expr == "*" {
+		return min, max, nil
+	}
+
+	bounds := strings.SplitN(expr, "-", 2)
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0, 0, errors.New("无效的cron值：" + expr)
+	}
+	end := start
+	if len(bounds) == 2 {
+		end, err = strconv.Atoi(bounds[1])
+		if err != nil {
+			return 0, 0, errors.New("无效的cron值：" + expr)
+		}
+	}
+
+	if start < min || end > max || start > end {
+		return 0, 0, errors.New("cron值超出范围：" + expr)
+	}
+	return start, end, nil
+}
+
+func getCronMask(min int, max int) uint64 {
+	var bits uint64
+	for i := min; i <= max; i++ {
+		bits |= 1 << uint(i)
+	}
+	return bits
+}
+
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		rangeExpr := part
+		if index := strings.Index(part, "/"); index >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[index+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.New("无效的cron步长：" + part)
+			}
+			rangeExpr = part[:index]
+		}
+
+		start, end, err := parseCronRange(rangeExpr, min, max)
+		if err != nil {
+			return 0, err
+		}
+		//a/n表示从a开始到最大值
+		if step > 1 && !strings.Contains(rangeExpr, "-") && rangeExpr != "*" {
+			end = max
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("cron表达式需要5段：" + expr)
+	}
+
+	var err error
+	schedule := &cronSchedule{}
+	if schedule.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if schedule.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if schedule.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	//周日可以是0或者7
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	//包含所有值时（*、*/1、0-6等）视为不限定
+	schedule.domStar = schedule.dom == getCronMask(1, 31)
+	schedule.dowStar = schedule.dow&getCronMask(0, 6) == getCronMask(0, 6)
+	return schedule, nil
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	//日和周都有限定时，满足任意一个即可
+	return domMatch || dowMatch
+}
+
+// 返回t之后的第一个触发时间，5年内没有则返回零值
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+		"@never",
+	}
+	for _, expr := range tests {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	//2024-01-01是周一
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", date(2024, 1, 1, 10, 7).Add(30 * time.Second), date(2024, 1, 1, 10, 8)},
+		{"*/15 * * * *", date(2024, 1, 1, 10, 7), date(2024, 1, 1, 10, 15)},
+		{"*/15 * * * *", date(2024, 1, 1, 10, 15), date(2024, 1, 1, 10, 30)},
+		{"5/20 * * * *", date(2024, 1, 1, 10, 6), date(2024, 1, 1, 10, 25)},
+		{"10-20/5 * * * *", date(2024, 1, 1, 10, 21), date(2024, 1, 1, 11, 10)},
+		{"0,30 8 * * *", date(2024, 1, 1, 8, 0), date(2024, 1, 1, 8, 30)},
+		{"0 9 * * 1-5", date(2024, 1, 5, 10, 0), date(2024, 1, 8, 9, 0)},
+		{"0 0 * * 7", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 0, 0)},
+		{"0 0 * * 0", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 0, 0)},
+		{"0 0 13 * 5", date(2024, 1, 1, 0, 0), date(2024, 1, 5, 0, 0)},
+		{"0 0 31 * *", date(2024, 4, 1, 0, 0), date(2024, 5, 31, 0, 0)},
+		{"30 2 29 2 *", date(2024, 3, 1, 0, 0), date(2028, 2, 29, 2, 30)},
+		{"@daily", date(2024, 1, 1, 0, 0), date(2024, 1, 2, 0, 0)},
+		{"@hourly", date(2024, 12, 31, 23, 30), date(2025, 1, 1, 0, 0)},
+		{"@weekly", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 0, 0)},
+		{"@monthly", date(2024, 1, 15, 0, 0), date(2024, 2, 1, 0, 0)},
+		{"@yearly", date(2024, 1, 1, 0, 0), date(2025, 1, 1, 0, 0)},
+		{"0 0 31 2 *", date(2024, 1, 1, 0, 0), time.Time{}},
+		//包含所有值的字段与*相同，只按另一个字段匹配
+		{"0 0 */1 * 1", date(2024, 1, 2, 0, 0), date(2024, 1, 8, 0, 0)},
+		{"0 0 1-31 * 1", date(2024, 1, 2, 0, 0), date(2024, 1, 8, 0, 0)},
+		{"0 0 13 * 0-6", date(2024, 1, 1, 0, 0), date(2024, 1, 13, 0, 0)},
+		{"0 0 13 * 1-7", date(2024, 1, 1, 0, 0), date(2024, 1, 13, 0, 0)},
+	}
+	for _, tt := range tests {
+		schedule, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := schedule.next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q.next(%v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
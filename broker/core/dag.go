@@ -0,0 +1,198 @@
+package core
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+type dagOut struct {
+	index  int
+	result interface{}
+	status int
+	start  time.Duration
+	end    time.Duration
+}
+
+// 按照dependsOn执行steps，依赖的step都结束后立即执行，同时执行的数量不超过concurrentNum。
+// 依赖的step失败时不再执行，failFast时第一个失败的step会取消其他step，其他joinMode按waitAll处理。
+func handleDagTasks(stack *hub.Stack, apis *[]hub.ScheduleApiDef, concurrentNum int, joinMode string, saga *sagaLog) (interface{}, int) {
+	tasks := *apis
+	deps, err := util.GetScheduleDag(tasks)
+	if err != nil {
+		str := "Schedule依赖关系错误：" + err.Error()
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, err), http.StatusInternalServerError
+	}
+	if concurrentNum < 1 {
+		concurrentNum = 1
+	}
+	logger.LogS().Infoln(stack.BaseString, "按依赖关系运行 steps:", len(tasks), " concurrentNum:", concurrentNum, " joinMode:", joinMode)
+
+	pending := make([]int, len(tasks))
+	dependents := make([][]int, len(tasks))
+	var ready []int
+	for i := range deps {
+		pending[i] = len(deps[i])
+		for _, dep := range deps[i] {
+			dependents[dep] = append(dependents[dep], i)
+		}
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	groupCtx, cancel := newGroupContext(stack)
+	defer cancel()
+
+	startTime := time.Now()
+	outs := make([]*dagOut, len(tasks))
+	var finished []int
+	out := make(chan dagOut, len(tasks))
+	running := 0
+	stopped := false
+
+	//在当前goroutine中写回heap，之后启动的step可以看到依赖的结果
+	complete := func(o dagOut) {
+		outs[o.index] = &o
+		finished = append(finished, o.index)
+		task := &tasks[o.index]
+		if key := getScheduleResultKey(task); isNormalMode(task) && len(key) > 0 {
+			stack.Heap.Set(key, o.result)
+		}
+		if o.status != http.StatusOK {
+			logger.LogS().Errorln(stack.BaseString, "step执行失败，不再执行依赖它的step：", getScheduleTaskName(task), " code:", o.status)
+			if joinMode == "failFast" && !stopped {
+				stopped = true
+				cancel()
+			}
+			return
+		}
+		saga.addScheduleTask(task)
+		for _, next := range dependents[o.index] {
+			pending[next]--
+			if pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+		//按照定义顺序启动
+		sort.Ints(ready)
+	}
+
+	for {
+		for running < concurrentNum && len(ready) > 0 && !stopped {
+			index := ready[0]
+			ready = ready[1:]
+			task := &tasks[index]
+			if _, _, ok := checkContext(stack, getScheduleTaskName(task)); !ok {
+				stopped = true
+				break
+			}
+
+			if task.Mode == "background" {
+				id := startBackgroundJob(stack, task)
+				hub.SetHeapMapValue(stack.Heap, hub.HeapJobName, getScheduleTaskName(task), id)
+				now := time.Since(startTime)
+				complete(dagOut{index: index, result: id, status: http.StatusOK, start: now, end: now})
+				continue
+			}
+
+			tmpStack := copyScheduleStack(stack, task)
+			tmpStack.Context = groupCtx
+			running++
+			go func(index int, tmpStack *hub.Stack) {
+				start := time.Since(startTime)
+				result, status := handleOneScheduleApi(tmpStack, &tasks[index])
+				out <- dagOut{index: index, result: result, status: status, start: start, end: time.Since(startTime)}
+			}(index, tmpStack)
+		}
+
+		if running == 0 {
+			break
+		}
+		complete(<-out)
+		running--
+	}
+
+	return getDagResult(stack, tasks, deps, outs, finished, time.Since(startTime))
+}
+
+// 按照定义顺序汇总结果，失败时返回第一个失败的step的状态码，未执行的step标记为skipped
+func getDagResult(stack *hub.Stack, tasks []hub.ScheduleApiDef, deps [][]int, outs []*dagOut, finished []int, duration time.Duration) (interface{}, int) {
+	failed := -1
+	results := make(map[string]interface{}, len(tasks))
+	branches := make([]interface{}, len(tasks))
+	for i := range tasks {
+		key := getScheduleResultKey(&tasks[i])
+		branch := map[string]interface{}{"index": i, "id": tasks[i].Id, "resultKey": key}
+		branches[i] = branch
+		o := outs[i]
+		if o == nil {
+			branch["skipped"] = true
+			continue
+		}
+		branch["code"] = o.status
+		branch["result"] = o.result
+		branch["start"] = o.start.Seconds()
+		branch["duration"] = (o.end - o.start).Seconds()
+		if len(key) > 0 {
+			results[key] = o.result
+		}
+		if o.status != http.StatusOK && failed < 0 {
+			failed = i
+		}
+	}
+
+	critical, path := getCriticalPath(tasks, deps, outs, finished)
+	logger.LogS().Infoln(stack.BaseString, "依赖关系运行结束，用时:", duration.Seconds(), " 关键路径:", path, " 用时:", critical.Seconds())
+	groupResult := map[string]interface{}{
+		"results":      results,
+		"branches":     branches,
+		"duration":     duration.Seconds(),
+		"criticalPath": map[string]interface{}{"steps": path, "duration": critical.Seconds()},
+	}
+
+	if cancelResult, code, ok := checkContext(stack, "dependsOn"); !ok {
+		return cancelResult, code
+	}
+	if failed >= 0 {
+		return groupResult, outs[failed].status
+	}
+	return groupResult, http.StatusOK
+}
+
+// 按照实际执行时间计算关键路径：每个step的累计用时为自身用时加上依赖中累计用时最长的一个
+func getCriticalPath(tasks []hub.ScheduleApiDef, deps [][]int, outs []*dagOut, finished []int) (time.Duration, []string) {
+	total := make([]time.Duration, len(tasks))
+	prev := make([]int, len(tasks))
+	last := -1
+	//finished按照结束顺序排列，依赖的step总是先结束
+	for _, i := range finished {
+		prev[i] = -1
+		for _, dep := range deps[i] {
+			if outs[dep] != nil && (prev[i] < 0 || total[dep] > total[prev[i]]) {
+				prev[i] = dep
+			}
+		}
+		total[i] = outs[i].end - outs[i].start
+		if prev[i] >= 0 {
+			total[i] += total[prev[i]]
+		}
+		if last < 0 || total[i] > total[last] {
+			last = i
+		}
+	}
+
+	if last < 0 {
+		return 0, []string{}
+	}
+	var path []string
+	for i := last; i >= 0; i = prev[i] {
+		path = append([]string{util.GetScheduleTaskId(tasks, i)}, path...)
+	}
+	return total[last], path
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func newTestDagTask(id string, code int, dependsOn ...string) hub.ScheduleApiDef {
+	task := newTestTask(id, code)
+	task.Id = id
+	task.DependsOn = dependsOn
+	return task
+}
+
+func TestDagTasksOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []hub.ScheduleApiDef
+		code  int
+		calls []string
+	}{
+		{"dependencies first", []hub.ScheduleApiDef{
+			newTestDagTask("c", http.StatusOK, "a", "b"),
+			newTestDagTask("a", http.StatusOK),
+			newTestDagTask("b", http.StatusOK, "a"),
+		}, http.StatusOK, []string{"a", "b", "c"}},
+		{"definition order when ready", []hub.ScheduleApiDef{
+			newTestDagTask("d", http.StatusOK, "a"),
+			newTestDagTask("a", http.StatusOK),
+			newTestDagTask("b", http.StatusOK),
+			newTestDagTask("c", http.StatusOK, "a"),
+		}, http.StatusOK, []string{"a", "d", "b", "c"}},
+		{"failed dependency", []hub.ScheduleApiDef{
+			newTestDagTask("a", http.StatusBadGateway),
+			newTestDagTask("b", http.StatusOK, "a"),
+			newTestDagTask("c", http.StatusOK),
+			newTestDagTask("d", http.StatusOK, "b"),
+		}, http.StatusBadGateway, []string{"a", "c"}},
+		{"cycle", []hub.ScheduleApiDef{
+			newTestDagTask("a", http.StatusOK, "b"),
+			newTestDagTask("b", http.StatusOK, "a"),
+		}, http.StatusInternalServerError, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestCalls()
+			//concurrentNum为1时按照依赖关系和定义顺序依次执行
+			result, code := handleDagTasks(newTestStack(), &tt.tasks, 1, "", nil)
+			if code != tt.code {
+				t.Fatalf("code = %d, want %d, result = %v", code, tt.code, result)
+			}
+			checkTestCalls(t, tt.calls...)
+		})
+	}
+}
+
+func TestDagTasksSkipped(t *testing.T) {
+	tasks := []hub.ScheduleApiDef{
+		newTestDagTask("a", http.StatusBadGateway),
+		newTestDagTask("b", http.StatusOK, "a"),
+	}
+	result, _ := handleDagTasks(newTestStack(), &tasks, 2, "", nil)
+	branches := result.(map[string]interface{})["branches"].([]interface{})
+	if skipped, _ := branches[1].(map[string]interface{})["skipped"].(bool); !skipped {
+		t.Fatalf("branches = %v", branches)
+	}
+}
+
+func TestCriticalPath(t *testing.T) {
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	tasks := []hub.ScheduleApiDef{{Id: "a"}, {Id: "b"}, {Id: "c"}, {}}
+	//a -> b, a -> c, b和c -> [3]
+	deps := [][]int{nil, {0}, {0}, {1, 2}}
+	tests := []struct {
+		name     string
+		outs     []*dagOut
+		finished []int
+		duration time.Duration
+		path     []string
+	}{
+		{"longest branch", []*dagOut{
+			{start: 0, end: ms(10)}, {start: ms(10), end: ms(40)}, {start: ms(10), end: ms(20)}, {start: ms(40), end: ms(45)},
+		}, []int{0, 2, 1, 3}, ms(45), []string{"a", "b", "[3]"}},
+		{"other branch", []*dagOut{
+			{start: 0, end: ms(10)}, {start: ms(10), end: ms(15)}, {start: ms(10), end: ms(30)}, {start: ms(30), end: ms(31)},
+		}, []int{0, 1, 2, 3}, ms(31), []string{"a", "c", "[3]"}},
+		{"skipped", []*dagOut{
+			{start: 0, end: ms(10)}, {start: ms(10), end: ms(15)}, nil, nil,
+		}, []int{0, 1}, ms(15), []string{"a", "b"}},
+		{"nothing finished", make([]*dagOut, 4), nil, 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duration, path := getCriticalPath(tasks, deps, tt.outs, tt.finished)
+			if duration != tt.duration || len(path) != len(tt.path) {
+				t.Fatalf("duration = %v, path = %v, want %v %v", duration, path, tt.duration, tt.path)
+			}
+			for i := range path {
+				if path[i] != tt.path[i] {
+					t.Fatalf("path = %v, want %v", path, tt.path)
+				}
+			}
+		})
+	}
+}
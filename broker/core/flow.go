@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/jasony62/tms-go-apihub/hub"
@@ -8,8 +9,10 @@ import (
 	"github.com/jasony62/tms-go-apihub/util"
 )
 
-func handleOneApi(stack *hub.Stack, apiDef *hub.ApiDef, private string) (result interface{}, ret int) {
-	if len(apiDef.Command) > 0 {
+func handleOneApi(stack *hub.Stack, apiDef *hub.ApiDef, private string, saga *sagaLog) (result interface{}, ret int) {
+	if len(apiDef.Parallel) > 0 {
+		result, ret = runParallelSteps(stack, apiDef, private, saga)
+	} else if len(apiDef.Command) > 0 {
 		result, ret = ApiRun(stack, apiDef, private, false)
 	} else {
 		result, ret = nil, http.StatusInternalServerError
@@ -17,39 +20,144 @@ func handleOneApi(stack *hub.Stack, apiDef *hub.ApiDef, private string) (result
 	return
 }
 
-func runFlow(stack *hub.Stack, name string, private string) (result interface{}, ret int) {
-	var code int
-	var lastResult string
-	flowDef, ok := util.FindFlowDef(name)
-	if !ok || flowDef == nil {
-		str := "获得Flow定义失败：" + name
+// 检查step的when条件，条件不成立时跳过该step
+func checkWhen(stack *hub.Stack, apiDef *hub.ApiDef) (bool, interface{}, int) {
+	if len(apiDef.When) == 0 {
+		return true, nil, http.StatusOK
+	}
+
+	ok, err := util.CheckCondition(stack, apiDef.When)
+	if err != nil {
+		str := "计算when条件失败：" + apiDef.Name + "，" + err.Error()
 		logger.LogS().Errorln(stack.BaseString, str)
-		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusForbidden
+		return false, util.CreateTmsError(hub.TmsErrorCoreId, str, err), http.StatusInternalServerError
+	}
+
+	if !ok {
+		logger.LogS().Infoln(stack.BaseString, "when条件不成立，跳过API：", apiDef.Name, " when:", apiDef.When)
+		return false, map[string]interface{}{"skipped": true, "when": apiDef.When}, http.StatusOK
 	}
+	return true, nil, http.StatusOK
+}
 
-	for i := range flowDef.Steps {
-		apiDef := flowDef.Steps[i]
+// 记录失败step的信息，便于onError中使用
+func setErrorHeap(stack *hub.Stack, name string, code int, result interface{}) {
+	stack.Heap.Set(hub.HeapErrorName, map[string]interface{}{"name": name, "code": code, "result": result})
+}
 
-		result, code = handleOneApi(stack, &apiDef, private)
+// step失败时执行step的onError，onError执行成功则用其结果作为step的结果
+func handleStepError(stack *hub.Stack, apiDef *hub.ApiDef, private string, result interface{}, code int) (interface{}, int) {
+	setErrorHeap(stack, apiDef.Name, code, result)
+	if len(apiDef.OnError) == 0 {
+		return result, code
+	}
+
+	logger.LogS().Infoln(stack.BaseString, "运行API：", apiDef.Name, "失败，执行onError")
+	return runSteps(stack, apiDef.OnError, private)
+}
+
+func runSteps(stack *hub.Stack, steps []hub.ApiDef, private string) (result interface{}, ret int) {
+	return runStepsWithSaga(stack, steps, private, nil)
+}
+
+// 成功的step记录到saga中，flow失败时执行compensate
+func runStepsWithSaga(stack *hub.Stack, steps []hub.ApiDef, private string, saga *sagaLog) (result interface{}, ret int) {
+	var code int
+	var lastResult string
+	for i := range steps {
+		apiDef := steps[i]
+		var ok bool
+		if result, code, ok = checkContext(stack, apiDef.Name); !ok {
+			setErrorHeap(stack, apiDef.Name, code, result)
+			return result, code
+		}
+
+		var run bool
+		var skipResult interface{}
+		run, skipResult, code = checkWhen(stack, &apiDef)
+		if code != http.StatusOK {
+			setErrorHeap(stack, apiDef.Name, code, skipResult)
+			return skipResult, code
+		}
+
+		if run {
+			result, code = handleOneApi(stack, &apiDef, private, saga)
+			if code != http.StatusOK {
+				result, code = handleStepError(stack, &apiDef, private, result, code)
+			}
+		} else {
+			result = skipResult
+		}
 		if code != http.StatusOK {
 			str := "运行API：" + apiDef.Name + "失败"
 			logger.LogS().Errorln(stack.BaseString, str)
+			//step返回的TmsError保留最初的Id和位置
+			if tmsErr, ok := result.(hub.TmsError); ok {
+				return tmsErr, code
+			}
 			return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), code
 		}
 
+		if run {
+			saga.addFlowStep(&apiDef, private)
+		}
+
 		if len(apiDef.ResultKey) > 0 {
-			stack.Heap[apiDef.ResultKey] = result
+			stack.Heap.Set(apiDef.ResultKey, result)
 			lastResult = apiDef.ResultKey
 		}
 	}
 
 	if len(lastResult) > 0 {
-		return stack.Heap[lastResult], http.StatusOK
+		result, _ = stack.Heap.Get(lastResult)
+		return result, http.StatusOK
 	} else {
 		return nil, http.StatusOK
 	}
 }
 
+// finally中的step总会执行，不受超时和取消的影响，结果只记录日志
+func runFinally(stack *hub.Stack, name string, steps []hub.ApiDef, private string) {
+	ctx := stack.Context
+	stack.Context = context.Background()
+	defer func() { stack.Context = ctx }()
+
+	result, code := runSteps(stack, steps, private)
+	if code != http.StatusOK {
+		logger.LogS().Errorln(stack.BaseString, "运行Flow：", name, "finally失败 result:", result)
+	}
+}
+
+func runFlow(stack *hub.Stack, name string, private string) (result interface{}, ret int) {
+	flowDef, ok := util.FindFlowDef(name)
+	if !ok || flowDef == nil {
+		str := "获得Flow定义失败：" + name
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusForbidden
+	}
+
+	defer enterNested(stack)()
+	restore := withTimeout(stack, flowDef.Timeout)
+	defer restore()
+
+	if len(flowDef.Finally) > 0 {
+		defer runFinally(stack, name, flowDef.Finally, private)
+	}
+
+	saga := &sagaLog{}
+	result, ret = runStepsWithSaga(stack, flowDef.Steps, private, saga)
+	if ret != http.StatusOK && len(flowDef.OnError) > 0 {
+		logger.LogS().Infoln(stack.BaseString, "运行Flow：", name, "失败，执行onError")
+		//onError不受flow自身超时的影响
+		restore()
+		result, ret = runSteps(stack, flowDef.OnError, private)
+	}
+	if ret != http.StatusOK {
+		result = saga.compensate(stack, name, result)
+	}
+	return result, ret
+}
+
 func runFlowApi(stack *hub.Stack, params map[string]string) (interface{}, int) {
 	name, OK := params["name"]
 	if !OK {
@@ -59,5 +167,8 @@ func runFlowApi(stack *hub.Stack, params map[string]string) (interface{}, int) {
 	}
 	private := params["private"]
 
+	if flowDef, ok := util.FindFlowDef(name); ok && flowDef != nil && isFlowIsolated(stack, flowDef) {
+		return runIsolatedFlow(stack, flowDef, name, private, params)
+	}
 	return runFlow(stack, name, private)
 }
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+func init() {
+	RegisterApis(map[string]hub.ApiHandler{
+		"flowTestPanic": func(stack *hub.Stack, params map[string]string) (interface{}, int) {
+			panic("flowTestPanic")
+		},
+		"flowTestOk": func(stack *hub.Stack, params map[string]string) (interface{}, int) {
+			return params["value"], http.StatusOK
+		},
+	})
+}
+
+func runTestFlow(t *testing.T, steps ...hub.ApiDef) (interface{}, int) {
+	t.Helper()
+	util.DefaultConfMap.FlowMap["flowTest"] = &hub.FlowDef{Name: "flowTest", Steps: steps}
+	t.Cleanup(func() { delete(util.DefaultConfMap.FlowMap, "flowTest") })
+	stack := &hub.Stack{Context: context.Background(), Heap: hub.NewHeap(nil)}
+	return runFlow(stack, "flowTest", "")
+}
+
+func TestFlowKeepsStepErrorId(t *testing.T) {
+	missingFunc := []hub.BaseParamDef{{Name: "value", Value: hub.BaseValueDef{From: "func", Content: "flowTestMissing"}}}
+	tests := []struct {
+		name string
+		step hub.ApiDef
+		id   uint
+		code int
+	}{
+		{"missing function", hub.ApiDef{Name: "a", Command: "flowTestOk", Args: &missingFunc}, hub.TmsErrorFuncId, http.StatusInternalServerError},
+		{"panic", hub.ApiDef{Name: "a", Command: "flowTestPanic"}, hub.TmsErrorPanicId, http.StatusInternalServerError},
+		{"unknown command", hub.ApiDef{Name: "a", Command: "flowTestUnknown"}, hub.TmsErrorCoreId, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok := hub.ApiDef{Name: "ok", Command: "flowTestOk"}
+			result, code := runTestFlow(t, ok, tt.step, ok)
+			tmsErr, isTmsErr := result.(hub.TmsError)
+			if code != tt.code || !isTmsErr || tmsErr.Id != tt.id {
+				t.Fatalf("code = %d, result = %v, want id %d", code, result, tt.id)
+			}
+		})
+	}
+}
+
+func TestCheckWhen(t *testing.T) {
+	stack := &hub.Stack{Heap: hub.NewHeap(map[string]interface{}{
+		hub.HeapOriginName: map[string]interface{}{"type": "weather", "count": 0, "flag": "false"},
+	})}
+	tests := []struct {
+		when string
+		run  bool
+		code int
+	}{
+		{"", true, http.StatusOK},
+		{`{{eq .origin.type "weather"}}`, true, http.StatusOK},
+		{`{{eq .origin.type "news"}}`, false, http.StatusOK},
+		{"{{.origin.count}}", false, http.StatusOK},
+		{"{{.origin.flag}}", false, http.StatusOK},
+		{"{{.origin.missing}}", false, http.StatusOK},
+		{"{{.origin.type}}", true, http.StatusOK},
+		{"{{if}}", false, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		run, result, code := checkWhen(stack, &hub.ApiDef{Name: "a", When: tt.when})
+		if run != tt.run || code != tt.code {
+			t.Errorf("when %q: run = %v, code = %d, result = %v", tt.when, run, code, result)
+		}
+		if !run && code == http.StatusOK {
+			skipped, _ := result.(map[string]interface{})
+			if skipped["skipped"] != true || skipped["when"] != tt.when {
+				t.Errorf("when %q: result = %v", tt.when, result)
+			}
+		}
+	}
+}
+
+func TestFlowSkipsStepByWhen(t *testing.T) {
+	value := func(content string) *[]hub.BaseParamDef {
+		return &[]hub.BaseParamDef{{Name: "value", Value: hub.BaseValueDef{From: "literal", Content: content}}}
+	}
+	result, code := runTestFlow(t,
+		hub.ApiDef{Name: "a", Command: "flowTestOk", Args: value("a"), ResultKey: "a"},
+		hub.ApiDef{Name: "b", Command: "flowTestPanic", When: `{{eq .a "b"}}`, ResultKey: "b"},
+		hub.ApiDef{Name: "c", Command: "flowTestOk", Args: value("c"), When: `{{eq .a "a"}}`, ResultKey: "c"},
+	)
+	if code != http.StatusOK || result != "c" {
+		t.Fatalf("code = %d, result = %v", code, result)
+	}
+
+	//跳过的step只有最后一个时，结果为跳过的记录
+	result, code = runTestFlow(t,
+		hub.ApiDef{Name: "a", Command: "flowTestOk", Args: value("a"), ResultKey: "a"},
+		hub.ApiDef{Name: "b", Command: "flowTestPanic", When: "{{.missing}}", ResultKey: "b"},
+	)
+	if skipped, _ := result.(map[string]interface{}); code != http.StatusOK || skipped["skipped"] != true {
+		t.Fatalf("code = %d, result = %v", code, result)
+	}
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+const (
+	JobStatusRunning  = "running"
+	JobStatusSuccess  = "success"
+	JobStatusFailed   = "failed"
+	JobStatusCanceled = "canceled"
+)
+
+// 最多保留的已结束job数量
+const maxFinishedJobs = 1000
+
+type backgroundJob struct {
+	id        string
+	name      string
+	status    string
+	startTime time.Time
+	endTime   time.Time
+	code      int
+	result    interface{}
+	err       string
+	cancel    context.CancelFunc
+}
+
+var jobLock sync.RWMutex
+var jobMap = make(map[string]*backgroundJob)
+
+func (job *backgroundJob) info() map[string]interface{} {
+	info := map[string]interface{}{
+		"id":     job.id,
+		"name":   job.name,
+		"status": job.status,
+		"start":  job.startTime.Format(time.RFC3339),
+	}
+	if job.status != JobStatusRunning {
+		info["end"] = job.endTime.Format(time.RFC3339)
+		info["duration"] = job.endTime.Sub(job.startTime).Seconds()
+		info["code"] = job.code
+		info["result"] = job.result
+		if len(job.err) > 0 {
+			info["error"] = job.err
+		}
+	}
+	return info
+}
+
+// 删除最早结束的job，调用时需要持有jobLock
+func cleanFinishedJobs() {
+	var finished []*backgroundJob
+	for _, job := range jobMap {
+		if job.status != JobStatusRunning {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].endTime.Before(finished[j].endTime)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(jobMap, job.id)
+	}
+}
+
+func finishJob(job *backgroundJob, result interface{}, code int) {
+	jobLock.Lock()
+	defer jobLock.Unlock()
+
+	job.endTime = time.Now()
+	job.code = code
+	job.result = result
+	if job.status == JobStatusCanceled {
+		return
+	}
+
+	if code == http.StatusOK {
+		job.status = JobStatusSuccess
+	} else {
+		job.status = JobStatusFailed
+		if tmsErr, ok := result.(hub.TmsError); ok {
+			job.err = tmsErr.ErrorMsg
+		}
+	}
+	cleanFinishedJobs()
+}
+
+// job中发生panic时记录为失败，不会导致整个服务退出
+func recoverJobPanic(stack *hub.Stack, job *backgroundJob) {
+	if r := recover(); r != nil {
+		str := "后台job执行失败：" + job.name + " panic:" + fmt.Sprint(r)
+		logger.LogS().Errorln(stack.BaseString, str, "\n", string(debug.Stack()))
+		finishJob(job, util.CreateTmsError(hub.TmsErrorPanicId, str, nil), http.StatusInternalServerError)
+	}
+}
+
+// 在独立的stack和context中运行background task，返回job id
+func startBackgroundJob(stack *hub.Stack, task *hub.ScheduleApiDef) string {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &backgroundJob{
+		id:        uuid.New().String(),
+		name:      getScheduleTaskName(task),
+		status:    JobStatusRunning,
+		startTime: time.Now(),
+		cancel:    cancel,
+	}
+
+	jobStack := copyScheduleStack(stack, task)
+	jobStack.Context = ctx
+	//请求结束后gin会复用Context，job中不能再访问收到的请求
+	jobStack.GinContext = nil
+	jobStack.BaseString = stack.BaseString + "job: " + job.id + ". "
+	//请求的trace在请求结束时保存，job使用新的trace，通过job id查询
+	jobStack.Trace = nil
+	if stack.Trace != nil {
+		StartTrace(jobStack, "job", job.name)
+	}
+
+	jobLock.Lock()
+	jobMap[job.id] = job
+	jobLock.Unlock()
+
+	logger.LogS().Infoln(stack.BaseString, "启动后台job：", job.id, " name:", job.name)
+	go func() {
+		var result interface{}
+		code := http.StatusInternalServerError
+		defer cancel()
+		defer func() { FinishTrace(jobStack, code, job.id) }()
+		defer recoverJobPanic(jobStack, job)
+		result, code = handleOneScheduleApi(jobStack, task)
+		finishJob(job, result, code)
+		logger.LogS().Infoln(jobStack.BaseString, "后台job结束 code:", code)
+	}()
+	return job.id
+}
+
+func ListJobs(status string) []interface{} {
+	jobLock.RLock()
+	defer jobLock.RUnlock()
+
+	jobs := make([]*backgroundJob, 0, len(jobMap))
+	for _, job := range jobMap {
+		if len(status) == 0 || job.status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].startTime.Before(jobs[j].startTime)
+	})
+
+	result := make([]interface{}, len(jobs))
+	for i, job := range jobs {
+		result[i] = job.info()
+	}
+	return result
+}
+
+func GetJob(id string) (map[string]interface{}, bool) {
+	jobLock.RLock()
+	defer jobLock.RUnlock()
+
+	job, ok := jobMap[id]
+	if !ok {
+		return nil, false
+	}
+	return job.info(), true
+}
+
+func CancelJob(id string) (map[string]interface{}, bool) {
+	jobLock.Lock()
+	defer jobLock.Unlock()
+
+	job, ok := jobMap[id]
+	if !ok {
+		return nil, false
+	}
+	if job.status == JobStatusRunning {
+		job.status = JobStatusCanceled
+		job.cancel()
+		logger.LogS().Infoln("取消后台job：", id)
+	}
+	return job.info(), true
+}
+
+func jobList(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	return ListJobs(params["status"]), http.StatusOK
+}
+
+func jobGet(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	id, OK := params["id"]
+	if !OK {
+		str := "缺少job id"
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusBadRequest
+	}
+
+	job, ok := GetJob(id)
+	if !ok {
+		str := "job不存在：" + id
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusNotFound
+	}
+	return job, http.StatusOK
+}
+
+func jobCancel(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	id, OK := params["id"]
+	if !OK {
+		str := "缺少job id"
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusBadRequest
+	}
+
+	job, ok := CancelJob(id)
+	if !ok {
+		str := "job不存在：" + id
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusNotFound
+	}
+	return job, http.StatusOK
+}
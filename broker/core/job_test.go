@@ -0,0 +1,77 @@
+package core
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func waitJob(t *testing.T, id string) map[string]interface{} {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		job, ok := GetJob(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job["status"] != JobStatusRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s not finished", id)
+	return nil
+}
+
+func TestBackgroundJobPanicMarksFailed(t *testing.T) {
+	//没有control的switch会panic
+	task := &hub.ScheduleApiDef{Type: "switch", Mode: "background"}
+	job := waitJob(t, startBackgroundJob(newTestStack(), task))
+	if job["status"] != JobStatusFailed || job["code"] != http.StatusInternalServerError {
+		t.Fatalf("job = %v", job)
+	}
+}
+
+func TestBackgroundJobWithoutRequest(t *testing.T) {
+	stack := newTestStack()
+	stack.GinContext = &gin.Context{}
+	args := []hub.BaseParamDef{{Name: "name", Value: hub.BaseValueDef{From: "header", Content: "X-Name"}}}
+	task := &hub.ScheduleApiDef{Type: "api", Mode: "background", Api: &hub.ApiDef{Name: "job", Command: "testStep", Args: &args}}
+
+	job := waitJob(t, startBackgroundJob(stack, task))
+	if job["status"] != JobStatusFailed {
+		t.Fatalf("job = %v", job)
+	}
+	if err, ok := job["result"].(hub.TmsError); !ok || err.Id != hub.TmsErrorNoRequestId {
+		t.Fatalf("result = %#v", job["result"])
+	}
+}
+
+func TestBackgroundJobStartsNewTrace(t *testing.T) {
+	resetTestCalls()
+	stack := newTestStack()
+	StartTrace(stack, "schedule", "test-job-trace")
+	task := &hub.ScheduleApiDef{Type: "api", Mode: "background", Api: newTestApi("job", http.StatusOK)}
+
+	id := startBackgroundJob(stack, task)
+	waitJob(t, id)
+	FinishTrace(stack, http.StatusOK, "test-job-trace")
+
+	//请求的trace中没有job的调用
+	trace, ok := GetTrace("test-job-trace")
+	if !ok || strings.Contains(string(trace), `"job"`) {
+		t.Fatalf("request trace = %s", trace)
+	}
+	for i := 0; i < 100; i++ {
+		if trace, ok = GetTrace(id); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !ok || !strings.Contains(string(trace), `"name":"job"`) {
+		t.Fatalf("job trace = %s", trace)
+	}
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+)
+
+// order小的中间件在外层，先执行；order相同时按注册顺序
+type apiMiddlewareEntry struct {
+	name    string
+	order   int
+	handler hub.ApiMiddleware
+}
+
+type httpMiddlewareEntry struct {
+	name    string
+	order   int
+	handler hub.HttpMiddleware
+}
+
+// 内置的post中间件在最外层，记录每次执行的结果和耗时
+const PostMiddlewareOrder = 0
+
+// 内置的trace中间件在最内层，记录实际传给API的参数
+const TraceMiddlewareOrder = 1000
+
+var middlewareLock sync.RWMutex
+
+// 注册时生成新的列表，执行时不需要加锁遍历
+var apiMiddlewares []apiMiddlewareEntry
+var httpMiddlewares []httpMiddlewareEntry
+
+func checkMiddlewareName(names []string, name string) {
+	for _, v := range names {
+		if v == name {
+			str := "中间件重名:" + name
+			logger.LogS().Errorln(str)
+			panic(str)
+		}
+	}
+}
+
+// 注册ApiRun的中间件，每次执行API（包括重试）时按order调用
+func RegisterApiMiddleware(name string, order int, handler hub.ApiMiddleware) {
+	middlewareLock.Lock()
+	defer middlewareLock.Unlock()
+
+	names := make([]string, len(apiMiddlewares))
+	for i := range apiMiddlewares {
+		names[i] = apiMiddlewares[i].name
+	}
+	checkMiddlewareName(names, name)
+
+	list := append(append([]apiMiddlewareEntry{}, apiMiddlewares...), apiMiddlewareEntry{name: name, order: order, handler: handler})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].order < list[j].order })
+	apiMiddlewares = list
+	logger.LogS().Infoln("注册API中间件：", name, " order:", order)
+}
+
+// 注册上游HTTP请求的中间件，每次发出请求时按order调用
+func RegisterHttpMiddleware(name string, order int, handler hub.HttpMiddleware) {
+	middlewareLock.Lock()
+	defer middlewareLock.Unlock()
+
+	names := make([]string, len(httpMiddlewares))
+	for i := range httpMiddlewares {
+		names[i] = httpMiddlewares[i].name
+	}
+	checkMiddlewareName(names, name)
+
+	list := append(append([]httpMiddlewareEntry{}, httpMiddlewares...), httpMiddlewareEntry{name: name, order: order, handler: handler})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].order < list[j].order })
+	httpMiddlewares = list
+	logger.LogS().Infoln("注册HTTP中间件：", name, " order:", order)
+}
+
+func runApiMiddlewares(stack *hub.Stack, call *hub.ApiCall, handler hub.ApiCallHandler) (interface{}, int) {
+	middlewareLock.RLock()
+	list := apiMiddlewares
+	middlewareLock.RUnlock()
+
+	for i := len(list) - 1; i >= 0; i-- {
+		middleware, next := list[i].handler, handler
+		handler = func(stack *hub.Stack, call *hub.ApiCall) (interface{}, int) {
+			return middleware(stack, call, next)
+		}
+	}
+	return handler(stack, call)
+}
+
+// 按order执行HTTP中间件，最后调用handler发出请求
+func RunHttpMiddlewares(stack *hub.Stack, call *hub.HttpCall, handler hub.HttpCallHandler) (interface{}, int, error) {
+	middlewareLock.RLock()
+	list := httpMiddlewares
+	middlewareLock.RUnlock()
+
+	for i := len(list) - 1; i >= 0; i-- {
+		middleware, next := list[i].handler, handler
+		handler = func(stack *hub.Stack, call *hub.HttpCall) (interface{}, int, error) {
+			return middleware(stack, call, next)
+		}
+	}
+	return handler(stack, call)
+}
+
+// 记录实际传给API的参数
+func traceMiddleware(stack *hub.Stack, call *hub.ApiCall, next hub.ApiCallHandler) (interface{}, int) {
+	setTraceArgs(stack, call.Api, call.Args)
+	return next(stack, call)
+}
+
+// 记录API每次执行的结果、耗时和第几次执行，internal调用时跳过
+func postMiddleware(stack *hub.Stack, call *hub.ApiCall, next hub.ApiCallHandler) (interface{}, int) {
+	if call.Internal {
+		return next(stack, call)
+	}
+
+	t := time.Now()
+	result, code := next(stack, call)
+	duration := time.Since(t).Seconds()
+	apiDef := call.Api
+	if code == http.StatusOK {
+		logger.LogS().Infoln("___post API OK: ", stack.BaseString, "command:"+apiDef.Command, " name："+apiDef.Name, " result:", result, " duration(s):", duration, " attempt:", call.Attempt)
+	} else {
+		logger.LogS().Errorln("!!!post API NOK:", stack.BaseString, "command :"+apiDef.Command, " name："+apiDef.Name, " result:", result, " duration(s):", duration, " attempt:", call.Attempt)
+	}
+	return result, code
+}
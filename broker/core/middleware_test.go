@@ -0,0 +1,62 @@
+package core
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+var testAttempts struct {
+	sync.Mutex
+	once     sync.Once
+	attempts []int
+}
+
+// 只记录name为testMiddleware的API每次执行的Attempt，不影响其他测试
+func registerTestAttemptMiddleware() {
+	testAttempts.once.Do(func() {
+		RegisterApiMiddleware("testAttempt", PostMiddlewareOrder+1, func(stack *hub.Stack, call *hub.ApiCall, next hub.ApiCallHandler) (interface{}, int) {
+			if call.Api.Name == "testMiddleware" {
+				testAttempts.Lock()
+				testAttempts.attempts = append(testAttempts.attempts, call.Attempt)
+				testAttempts.Unlock()
+			}
+			return next(stack, call)
+		})
+	})
+}
+
+func TestDefaultApiMiddlewares(t *testing.T) {
+	middlewareLock.RLock()
+	list := apiMiddlewares
+	middlewareLock.RUnlock()
+
+	if len(list) < 2 || list[0].name != "post" || list[len(list)-1].name != "trace" {
+		names := make([]string, len(list))
+		for i := range list {
+			names[i] = list[i].name
+		}
+		t.Fatalf("middlewares = %v, want post first and trace last", names)
+	}
+}
+
+func TestApiMiddlewareAttempt(t *testing.T) {
+	registerTestAttemptMiddleware()
+	testAttempts.Lock()
+	testAttempts.attempts = nil
+	testAttempts.Unlock()
+
+	apiDef := newTestApi("testMiddleware", http.StatusBadGateway)
+	apiDef.Retry = &hub.RetryDef{MaxAttempts: 3, Interval: 1}
+	if _, code := ApiRun(newTestStack(), apiDef, "", false); code != http.StatusBadGateway {
+		t.Fatalf("code = %d", code)
+	}
+
+	testAttempts.Lock()
+	defer testAttempts.Unlock()
+	if len(testAttempts.attempts) != 3 || testAttempts.attempts[0] != 1 || testAttempts.attempts[2] != 3 {
+		t.Fatalf("attempts = %v", testAttempts.attempts)
+	}
+}
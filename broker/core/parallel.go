@@ -0,0 +1,109 @@
+package core
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+)
+
+// parallel中所有step结束后才返回成功，不考虑失败
+const joinModeAllSettled = "allSettled"
+
+type parallelOut struct {
+	result interface{}
+	status int
+}
+
+// 在heap的副本中并行执行parallel中的step，所有step结束后按照定义顺序把resultKey写回heap。
+// joinMode同schedule，另外支持allSettled：失败的step只记录在branches中，分组总是成功。
+// 成功执行的step的compensate按照定义顺序合并到saga中，与分组是否成功无关。
+func runParallelSteps(stack *hub.Stack, apiDef *hub.ApiDef, private string, saga *sagaLog) (interface{}, int) {
+	restore := withTimeout(stack, apiDef.Timeout)
+	defer restore()
+
+	steps := apiDef.Parallel
+	groupCtx, cancel := newGroupContext(stack)
+	defer cancel()
+
+	concurrentNum := apiDef.ConcurrentNum
+	if concurrentNum <= 0 || concurrentNum > len(steps) {
+		concurrentNum = len(steps)
+	}
+	logger.LogS().Infoln(stack.BaseString, "并行执行：", apiDef.Name, " steps:", len(steps), " concurrentNum:", concurrentNum, " joinMode:", apiDef.JoinMode)
+
+	outs := make([]parallelOut, len(steps))
+	stacks := make([]*hub.Stack, len(steps))
+	sagas := make([]*sagaLog, len(steps))
+	sem := make(chan struct{}, concurrentNum)
+	failed, winner := -1, -1
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for i := range steps {
+		stacks[i] = copyScheduleStack(stack, nil)
+		stacks[i].Context = groupCtx
+		if saga != nil {
+			sagas[i] = &sagaLog{}
+		}
+		//按照定义顺序启动
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result, status := runStepsWithSaga(stacks[index], steps[index:index+1], private, sagas[index])
+
+			lock.Lock()
+			defer lock.Unlock()
+			outs[index] = parallelOut{result: result, status: status}
+			switch apiDef.JoinMode {
+			case "failFast":
+				if status != http.StatusOK && failed < 0 {
+					failed = index
+					cancel()
+				}
+			case "firstSuccess":
+				if status == http.StatusOK && winner < 0 {
+					winner = index
+					cancel()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	saga.merge(sagas)
+
+	//所有step结束后按照定义顺序写回heap，结果与完成顺序无关
+	results := make(map[string]interface{}, len(steps))
+	branches := make([]interface{}, len(steps))
+	for i := range steps {
+		key := steps[i].ResultKey
+		branches[i] = map[string]interface{}{"index": i, "name": steps[i].Name, "resultKey": key, "code": outs[i].status, "result": outs[i].result}
+		if outs[i].status != http.StatusOK {
+			if failed < 0 && apiDef.JoinMode != joinModeAllSettled {
+				failed = i
+			}
+			continue
+		}
+		if len(key) > 0 && (apiDef.JoinMode != "firstSuccess" || i == winner) {
+			value, _ := stacks[i].Heap.Get(key)
+			results[key] = value
+			stack.Heap.Set(key, value)
+		}
+	}
+
+	groupResult := map[string]interface{}{"results": results, "branches": branches}
+	status := http.StatusOK
+	if apiDef.JoinMode == "firstSuccess" && winner >= 0 {
+		groupResult["winner"] = winner
+	} else if failed >= 0 {
+		status = outs[failed].status
+		setErrorHeap(stack, steps[failed].Name, status, outs[failed].result)
+	}
+	if errResult, code, ok := checkContext(stack, apiDef.Name); !ok {
+		return errResult, code
+	}
+	return groupResult, status
+}
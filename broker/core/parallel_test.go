@@ -0,0 +1,74 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func newTestCompensateApi(name string, code int) hub.ApiDef {
+	apiDef := newTestApi(name, code)
+	apiDef.Compensate = []hub.ApiDef{*newTestApi("undo-"+name, http.StatusOK)}
+	return *apiDef
+}
+
+func TestParallelStepsCompensateInDefinitionOrder(t *testing.T) {
+	tests := []struct {
+		name          string
+		joinMode      string
+		concurrentNum int
+		parallel      []hub.ApiDef
+		steps         []hub.ApiDef
+		want          []string
+	}{
+		{
+			name:     "flow fails after group",
+			parallel: []hub.ApiDef{newTestCompensateApi("b", http.StatusOK), newTestCompensateApi("c", http.StatusOK)},
+			steps:    []hub.ApiDef{*newTestApi("fail", http.StatusInternalServerError)},
+			want:     []string{"fail", "undo-c", "undo-b", "undo-a"},
+		},
+		{
+			name:          "group fails",
+			joinMode:      "failFast",
+			concurrentNum: 1,
+			parallel:      []hub.ApiDef{newTestCompensateApi("b", http.StatusOK), newTestCompensateApi("c", http.StatusInternalServerError)},
+			want:          []string{"undo-b", "undo-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestCalls()
+			group := hub.ApiDef{Name: "group", Parallel: tt.parallel, JoinMode: tt.joinMode, ConcurrentNum: tt.concurrentNum}
+			steps := append([]hub.ApiDef{newTestCompensateApi("a", http.StatusOK), group}, tt.steps...)
+			addTestFlow(t, &hub.FlowDef{Name: "testParallelFlow", Steps: steps})
+
+			_, code := runFlow(newTestStack(), "testParallelFlow", "")
+			if code == http.StatusOK {
+				t.Fatalf("code = %d", code)
+			}
+			//并行的step完成顺序不固定，只检查之后的调用
+			calls := getTestCalls()
+			if len(calls) != 3+len(tt.want) || calls[0] != "a" {
+				t.Fatalf("calls = %v", calls)
+			}
+			for i, name := range tt.want {
+				if calls[3+i] != name {
+					t.Fatalf("calls = %v, want %v after the group", calls, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParallelFirstSuccessAllFailed(t *testing.T) {
+	resetTestCalls()
+	group := hub.ApiDef{Name: "group", JoinMode: "firstSuccess", Parallel: []hub.ApiDef{
+		*newTestApi("a", http.StatusBadGateway), *newTestApi("b", http.StatusInternalServerError),
+	}}
+	addTestFlow(t, &hub.FlowDef{Name: "testFirstSuccessFlow", Steps: []hub.ApiDef{group}})
+
+	if result, code := runFlow(newTestStack(), "testFirstSuccessFlow", ""); code != http.StatusBadGateway {
+		t.Fatalf("code = %d, result = %v", code, result)
+	}
+}
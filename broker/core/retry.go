@@ -0,0 +1,84 @@
+package core
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+)
+
+const defaultRetryInterval = 1000
+
+func isRetryCode(retry *hub.RetryDef, code int) bool {
+	if code == http.StatusOK {
+		return false
+	}
+
+	// 未配置codes时，只对5xx和429进行重试
+	if len(retry.Codes) == 0 {
+		return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+	}
+
+	for _, c := range retry.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func getRetryDelay(retry *hub.RetryDef, attempt int) time.Duration {
+	interval := retry.Interval
+	if interval <= 0 {
+		interval = defaultRetryInterval
+	}
+
+	delay := time.Duration(interval) * time.Millisecond
+	if retry.Backoff == "exponential" {
+		for i := 1; i < attempt && i < 16; i++ {
+			delay *= 2
+		}
+	}
+
+	if retry.MaxInterval > 0 && delay > time.Duration(retry.MaxInterval)*time.Millisecond {
+		delay = time.Duration(retry.MaxInterval) * time.Millisecond
+	}
+
+	if retry.Jitter && delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+	}
+	return delay
+}
+
+func setRetryAttempt(stack *hub.Stack, name string, attempt int) {
+	hub.SetHeapMapValue(stack.Heap, hub.HeapRetryName, name, attempt)
+}
+
+// 根据retry定义重复执行，返回最后一次的结果和执行次数，context结束后不再重试
+func runWithRetry(stack *hub.Stack, name string, retry *hub.RetryDef, run func() (interface{}, int)) (result interface{}, ret int, attempts int) {
+	if retry == nil || retry.MaxAttempts <= 1 {
+		result, ret = run()
+		return result, ret, 1
+	}
+
+	for attempts = 1; attempts <= retry.MaxAttempts; attempts++ {
+		setRetryAttempt(stack, name, attempts)
+		result, ret = run()
+		if !isRetryCode(retry, ret) || attempts == retry.MaxAttempts {
+			break
+		}
+		//超时或者取消导致的失败不再重试
+		if _, _, ok := checkContext(stack, name); !ok {
+			break
+		}
+
+		delay := getRetryDelay(retry, attempts)
+		logger.LogS().Warnln(stack.BaseString, "重试API：", name, " code:", ret, " attempt:", attempts, "/", retry.MaxAttempts, " delay:", delay)
+		if !sleepWithContext(stack, int(delay/time.Millisecond)) {
+			break
+		}
+	}
+	return result, ret, attempts
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func TestRunWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		retry    *hub.RetryDef
+		codes    []int
+		attempts int
+		code     int
+	}{
+		{"no retry", nil, []int{500, 200}, 1, 500},
+		{"retry 5xx", &hub.RetryDef{MaxAttempts: 3, Interval: 1}, []int{500, 502, 200}, 3, 200},
+		{"retry 429", &hub.RetryDef{MaxAttempts: 3, Interval: 1}, []int{429, 200}, 2, 200},
+		{"no retry 4xx", &hub.RetryDef{MaxAttempts: 3, Interval: 1}, []int{404, 200}, 1, 404},
+		{"max attempts", &hub.RetryDef{MaxAttempts: 2, Interval: 1}, []int{500, 500, 200}, 2, 500},
+		{"codes", &hub.RetryDef{MaxAttempts: 3, Interval: 1, Codes: []int{404}}, []int{404, 500, 200}, 2, 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			_, code, attempts := runWithRetry(newTestStack(), tt.name, tt.retry, func() (interface{}, int) {
+				code := tt.codes[calls]
+				calls++
+				return nil, code
+			})
+			if code != tt.code || attempts != tt.attempts || calls != tt.attempts {
+				t.Fatalf("code = %d, attempts = %d, calls = %d", code, attempts, calls)
+			}
+		})
+	}
+}
+
+func TestRunWithRetryStopsWhenContextDone(t *testing.T) {
+	stack := newTestStack()
+	ctx, cancel := context.WithCancel(context.Background())
+	stack.Context = ctx
+
+	calls := 0
+	start := time.Now()
+	_, code, attempts := runWithRetry(stack, "cancel", &hub.RetryDef{MaxAttempts: 5, Interval: 10000}, func() (interface{}, int) {
+		calls++
+		cancel()
+		return nil, http.StatusGatewayTimeout
+	})
+	if code != http.StatusGatewayTimeout || attempts != 1 || calls != 1 {
+		t.Fatalf("code = %d, attempts = %d, calls = %d", code, attempts, calls)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("retry slept after context was canceled")
+	}
+}
+
+func TestRunWithRetrySleepCanceled(t *testing.T) {
+	stack := newTestStack()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stack.Context = ctx
+
+	calls := 0
+	start := time.Now()
+	runWithRetry(stack, "timeout", &hub.RetryDef{MaxAttempts: 5, Interval: 10000}, func() (interface{}, int) {
+		calls++
+		return nil, http.StatusBadGateway
+	})
+	if calls != 1 || time.Since(start) > time.Second {
+		t.Fatalf("calls = %d, elapsed = %v", calls, time.Since(start))
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+)
+
+type sagaStep struct {
+	name     string
+	private  string
+	flow     []hub.ApiDef
+	schedule *[]hub.ScheduleApiDef
+}
+
+// 按照完成顺序记录定义了compensate的step，flow或schedule失败时逆序执行compensate
+type sagaLog struct {
+	steps []sagaStep
+}
+
+func (s *sagaLog) addFlowStep(apiDef *hub.ApiDef, private string) {
+	if s == nil || len(apiDef.Compensate) == 0 {
+		return
+	}
+	s.steps = append(s.steps, sagaStep{name: apiDef.Name, private: private, flow: apiDef.Compensate})
+}
+
+func (s *sagaLog) addScheduleTask(task *hub.ScheduleApiDef) {
+	if s == nil || task.Mode == "background" || task.Compensate == nil || len(*task.Compensate) == 0 {
+		return
+	}
+	s.steps = append(s.steps, sagaStep{name: getScheduleTaskName(task), private: task.Private, schedule: task.Compensate})
+}
+
+// 并行分组中每个step使用自己的saga，分组结束后按照定义顺序合并
+func (s *sagaLog) merge(children []*sagaLog) {
+	if s == nil {
+		return
+	}
+	for _, child := range children {
+		if child != nil {
+			s.steps = append(s.steps, child.steps...)
+		}
+	}
+}
+
+// 逆序执行所有compensate，不受超时和取消的影响，某个compensate失败时继续执行其他的。
+// 执行结果保存在.compensate中，并和原来的失败结果一起返回。
+func (s *sagaLog) compensate(stack *hub.Stack, name string, result interface{}) interface{} {
+	if s == nil || len(s.steps) == 0 {
+		return result
+	}
+	logger.LogS().Infoln(stack.BaseString, "运行：", name, "失败，执行compensate，数量:", len(s.steps))
+
+	ctx := stack.Context
+	stack.Context = context.Background()
+	defer func() { stack.Context = ctx }()
+
+	outcomes := make([]interface{}, 0, len(s.steps))
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := &s.steps[i]
+		node, restore := enterTrace(stack, &hub.ApiDef{Command: "compensate", Name: step.name})
+
+		var stepResult interface{}
+		var code int
+		if step.schedule != nil {
+			stepResult, code = handleTasks(stack, step.schedule, 0, "")
+		} else {
+			stepResult, code = runSteps(stack, step.flow, step.private)
+		}
+		restore()
+		finishTraceNode(node, stepResult, code, 1)
+
+		if code != http.StatusOK {
+			logger.LogS().Errorln(stack.BaseString, "compensate失败：", step.name, " code:", code, " result:", stepResult)
+		} else {
+			logger.LogS().Infoln(stack.BaseString, "compensate成功：", step.name)
+		}
+		outcomes = append(outcomes, map[string]interface{}{"name": step.name, "code": code, "result": stepResult})
+	}
+
+	stack.Heap.Set(hub.HeapCompensateName, outcomes)
+	return map[string]interface{}{"error": result, "compensate": outcomes}
+}
@@ -1,8 +1,13 @@
 package core
 
 import (
+	"context"
 	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/jasony62/tms-go-apihub/hub"
 	"github.com/jasony62/tms-go-apihub/logger"
@@ -21,13 +26,16 @@ type concurrentLoopOut struct {
 }
 
 type concurrentScheIn struct {
+	index int
 	stack *hub.Stack
 	task  *hub.ScheduleApiDef
 }
 
 type concurrentScheOut struct {
+	index  int
 	task   *hub.ScheduleApiDef
 	result interface{}
+	status int
 }
 
 func isNormalMode(task *hub.ScheduleApiDef) bool {
@@ -35,60 +43,98 @@ func isNormalMode(task *hub.ScheduleApiDef) bool {
 	return (mode != "concurrent") && (mode != "background")
 }
 
+// 在heap的快照中运行，heap中map类型的值只通过copy-on-write修改，与其他stack互不影响
 func copyScheduleStack(src *hub.Stack, task *hub.ScheduleApiDef) *hub.Stack {
-	result := hub.Stack{
+	heap := src.Heap.Snapshot()
+	/*don't copy this one*/
+	delete(heap, hub.HeapVarsName)
+
+	return &hub.Stack{
 		GinContext: src.GinContext,
-		Heap:       make(map[string]interface{}),
+		Context:    src.Context,
+		Heap:       hub.NewHeap(heap),
 		BaseString: src.BaseString,
+		Trace:      src.Trace,
+		DryRun:     src.DryRun,
+		Nested:     src.Nested,
 	}
+}
 
-	for k, v := range src.Heap {
-		switch k {
-		case hub.HeapOriginName:
-			oriLoop := src.Heap[k].(map[string]interface{})
-			loop := make(map[string]interface{}, len(oriLoop))
-			for index, element := range oriLoop {
-				loop[index] = element
-			}
-			result.Heap[k] = loop
-		case hub.HeapLoopName:
-			oriLoop := src.Heap[k].(map[string]int)
-			loop := make(map[string]int, len(oriLoop))
-			for index, element := range oriLoop {
-				loop[index] = element
-			}
-			result.Heap[k] = loop
-		case hub.HeapVarsName:
-			/*don't copy this one*/
-		default:
-			result.Heap[k] = v
+// case中value、values、regex、min/max任意一个满足即匹配
+func matchSwitchCase(stack *hub.Stack, item *hub.ScheduleSwitchCaseDef, key string) bool {
+	if len(item.Value) > 0 && item.Value == key {
+		return true
+	}
+
+	for _, v := range item.Values {
+		if v == key {
+			return true
 		}
+	}
 
+	if len(item.Regex) > 0 {
+		matched, err := regexp.MatchString(item.Regex, key)
+		if err != nil {
+			logger.LogS().Errorln(stack.BaseString, "无效的switch regex：", item.Regex, " err:", err)
+		} else if matched {
+			return true
+		}
 	}
 
-	return &result
+	if item.Min != nil || item.Max != nil {
+		value, err := strconv.ParseFloat(key, 64)
+		if err == nil && (item.Min == nil || value >= *item.Min) && (item.Max == nil || value <= *item.Max) {
+			return true
+		}
+	}
+	return false
 }
 
-func handleSwitchTask(stack *hub.Stack, task *hub.ScheduleApiDef) (interface{}, int) {
+func handleSwitchTask(stack *hub.Stack, task *hub.ScheduleApiDef) (result interface{}, status int) {
 	key, _ := util.GetParameterStringValue(stack, nil, &task.Control.Key)
+	cases := *task.Control.Cases
+
+	matched := -1
+	if len(key) > 0 {
+		for i := range cases {
+			if !cases[i].Default && matchSwitchCase(stack, &cases[i], key) {
+				matched = i
+				break
+			}
+		}
+	}
 
-	if len(key) == 0 {
-		err := "invalid switch key"
-		logger.LogS().Errorln(stack.BaseString, err)
-		return util.CreateTmsError(hub.TmsErrorCoreId, err, nil), http.StatusInternalServerError
+	if matched < 0 {
+		for i := range cases {
+			if cases[i].Default {
+				logger.LogS().Infoln(stack.BaseString, "switch执行default，key:", key)
+				matched = i
+				break
+			}
+		}
+	}
+
+	if matched < 0 {
+		if len(key) == 0 {
+			err := "invalid switch key"
+			logger.LogS().Errorln(stack.BaseString, err)
+			return util.CreateTmsError(hub.TmsErrorCoreId, err, nil), http.StatusInternalServerError
+		}
+		return util.CreateTmsError(hub.TmsErrorCoreId, "No task control", nil), http.StatusInternalServerError
 	}
 
-	for _, item := range *task.Control.Cases {
-		if item.Value == key {
-			return handleTasks(stack, item.Steps, task.Control.ConcurrentNum)
+	for i := matched; i < len(cases); i++ {
+		result, status = handleTasks(stack, cases[i].Steps, task.Control.ConcurrentNum, task.Control.JoinMode)
+		if status != http.StatusOK || !cases[i].Fallthrough {
+			break
 		}
 	}
-	return util.CreateTmsError(hub.TmsErrorCoreId, "No task control", nil), http.StatusInternalServerError
+	return result, status
 }
 
 func concurrentLoopWorker(apis chan concurrentLoopIn, out chan concurrentLoopOut, needResult bool) {
 	for task := range apis {
-		result, _ := handleTasks(task.stack, task.task, 0)
+		result, _ := handleTasks(task.stack, task.task, 0, "")
 		// 增加对task.Control.ResultKey的判断，若ResultKey == ""，则不输出result
 		if needResult {
 			out <- concurrentLoopOut{index: task.index, result: result}
@@ -98,7 +144,8 @@ func concurrentLoopWorker(apis chan concurrentLoopIn, out chan concurrentLoopOut
 	}
 }
 
-func triggerConcurrentLoop(stack *hub.Stack, task *hub.ScheduleApiDef, loopLength int, loop map[string]int, loopResult []interface{}) {
+// bind用于在复制后的stack中设置第index次循环的变量
+func triggerConcurrentLoop(stack *hub.Stack, task *hub.ScheduleApiDef, loopLength int, bind func(*hub.Stack, int), loopResult []interface{}) {
 	var taskCount, msgCount int
 	counter := loopLength
 
@@ -121,8 +168,9 @@ func triggerConcurrentLoop(stack *hub.Stack, task *hub.ScheduleApiDef, loopLengt
 	i := 0
 
 	for ; i < msgCount; i++ {
-		loop[task.Control.ResultKey] = i
-		in <- concurrentLoopIn{index: i, stack: copyScheduleStack(stack, task), task: task.Control.Steps}
+		tmpStack := copyScheduleStack(stack, task)
+		bind(tmpStack, i)
+		in <- concurrentLoopIn{index: i, stack: tmpStack, task: task.Control.Steps}
 	}
 
 	for i = 0; i < taskCount; i++ {
@@ -135,8 +183,8 @@ func triggerConcurrentLoop(stack *hub.Stack, task *hub.ScheduleApiDef, loopLengt
 		counter--
 		logger.LogS().Infoln(stack.BaseString, "loop并行处理结束：", counter, " result:", result)
 		if i < loopLength {
-			loop[task.Control.ResultKey] = i
 			tmpStack := copyScheduleStack(stack, task)
+			bind(tmpStack, i)
 			in <- concurrentLoopIn{index: i, stack: tmpStack, task: task.Control.Steps}
 			i++
 		} else if counter == 0 {
@@ -145,6 +193,13 @@ func triggerConcurrentLoop(stack *hub.Stack, task *hub.ScheduleApiDef, loopLengt
 	}
 }
 
+// 保存loopResult的副本，之后对loopResult的修改不影响heap中的值
+func setLoopResult(stack *hub.Stack, task *hub.ScheduleApiDef, loopResult []interface{}) {
+	if isNormalMode(task) && len(task.Control.ResultKey) > 0 {
+		stack.Heap.Set(task.Control.ResultKey, append([]interface{}{}, loopResult...))
+	}
+}
+
 func handleLoopTask(stack *hub.Stack, task *hub.ScheduleApiDef) (interface{}, int) {
 	var result interface{}
 	keyStr, _ := util.GetParameterStringValue(stack, nil, &task.Control.Key)
@@ -156,37 +211,221 @@ func handleLoopTask(stack *hub.Stack, task *hub.ScheduleApiDef) (interface{}, in
 	}
 	loopLength, _ := strconv.Atoi(keyStr)
 	loopResult := make([]interface{}, loopLength)
-	if isNormalMode(task) && len(task.Control.ResultKey) > 0 {
-		stack.Heap[task.Control.ResultKey] = loopResult
-	}
+	setLoopResult(stack, task, loopResult)
 
-	loop := stack.Heap[hub.HeapLoopName].(map[string]int)
 	if task.Control.ConcurrentLoopNum > 1 && loopLength > 1 {
-		triggerConcurrentLoop(stack, task, loopLength, loop, loopResult)
+		triggerConcurrentLoop(stack, task, loopLength, func(tmpStack *hub.Stack, i int) {
+			hub.SetHeapMapValue(tmpStack.Heap, hub.HeapLoopName, task.Control.Name, i)
+			hub.SetHeapMapValue(tmpStack.Heap, hub.HeapLoopName, task.Control.ResultKey, i)
+		}, loopResult)
+		setLoopResult(stack, task, loopResult)
 	} else {
 		for i := 0; i < loopLength; i++ {
-			loop[task.Control.Name] = i
-			result, _ = handleTasks(stack, task.Control.Steps, task.Control.ConcurrentNum)
+			if cancelResult, code, ok := checkContext(stack, task.Control.Name); !ok {
+				return cancelResult, code
+			}
+			hub.SetHeapMapValue(stack.Heap, hub.HeapLoopName, task.Control.Name, i)
+			result, _ = handleTasks(stack, task.Control.Steps, task.Control.ConcurrentNum, task.Control.JoinMode)
 			// 增加对task.Control.ResultKey的判断，若ResultKey == ""，则不添加到loopResult
 			if len(task.Control.ResultKey) > 0 {
 				loopResult[i] = result
+				setLoopResult(stack, task, loopResult)
 			}
 		}
 	}
 	return loopResult, 200
 }
 
+// 将数组或者对象转换为有序的key和value列表，对象按照key排序
+func getForeachItems(value interface{}) ([]interface{}, []interface{}, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		keys := make([]interface{}, v.Len())
+		values := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			keys[i] = i
+			values[i] = v.Index(i).Interface()
+		}
+		return keys, values, true
+	case reflect.Map:
+		mapKeys := v.MapKeys()
+		names := make([]string, 0, len(mapKeys))
+		for _, k := range mapKeys {
+			if k.Kind() != reflect.String {
+				return nil, nil, false
+			}
+			names = append(names, k.String())
+		}
+		sort.Strings(names)
+		keys := make([]interface{}, len(names))
+		values := make([]interface{}, len(names))
+		for i, name := range names {
+			keys[i] = name
+			values[i] = v.MapIndex(reflect.ValueOf(name)).Interface()
+		}
+		return keys, values, true
+	default:
+		return nil, nil, false
+	}
+}
+
+func handleForeachTask(stack *hub.Stack, task *hub.ScheduleApiDef) (interface{}, int) {
+	var result interface{}
+	value, err := util.GetParameterRawValue(stack, nil, &task.Control.Key)
+	if err != nil {
+		str := "invalid foreach key：" + err.Error()
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.ErrorResult(hub.TmsErrorCoreId, http.StatusInternalServerError, str, err)
+	}
+
+	keys, values, ok := getForeachItems(value)
+	if !ok {
+		str := "foreach key不是数组或者对象：" + task.Control.Name
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
+	}
+
+	loopLength := len(values)
+	loopResult := make([]interface{}, loopLength)
+	setLoopResult(stack, task, loopResult)
+
+	bind := func(tmpStack *hub.Stack, i int) {
+		hub.SetHeapMapValue(tmpStack.Heap, hub.HeapLoopName, task.Control.Name, i)
+		hub.SetHeapMapValue[interface{}](tmpStack.Heap, hub.HeapForeachName, task.Control.Name, map[string]interface{}{"index": i, "key": keys[i], "value": values[i]})
+	}
+
+	if task.Control.ConcurrentLoopNum > 1 && loopLength > 1 {
+		triggerConcurrentLoop(stack, task, loopLength, bind, loopResult)
+		setLoopResult(stack, task, loopResult)
+	} else {
+		for i := 0; i < loopLength; i++ {
+			if cancelResult, code, ok := checkContext(stack, task.Control.Name); !ok {
+				return cancelResult, code
+			}
+			bind(stack, i)
+			result, _ = handleTasks(stack, task.Control.Steps, task.Control.ConcurrentNum, task.Control.JoinMode)
+			if len(task.Control.ResultKey) > 0 {
+				loopResult[i] = result
+				setLoopResult(stack, task, loopResult)
+			}
+		}
+	}
+	return loopResult, http.StatusOK
+}
+
+// 按照毫秒等待，context取消时返回false
+func sleepWithContext(stack *hub.Stack, delay int) bool {
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(time.Duration(delay) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-util.GetContext(stack).Done():
+		return false
+	}
+}
+
+// while在条件成立时继续循环，until在条件成立时结束循环，条件在每次循环后检查
+func handleWhileTask(stack *hub.Stack, task *hub.ScheduleApiDef, until bool) (result interface{}, status int) {
+	if task.Control.MaxIteration <= 0 {
+		str := "缺少maxIteration：" + task.Control.Name
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
+	}
+
+	for i := 0; i < task.Control.MaxIteration; i++ {
+		if cancelResult, code, ok := checkContext(stack, task.Control.Name); !ok {
+			return cancelResult, code
+		}
+
+		hub.SetHeapMapValue(stack.Heap, hub.HeapLoopName, task.Control.Name, i)
+		result, status = handleTasks(stack, task.Control.Steps, task.Control.ConcurrentNum, task.Control.JoinMode)
+		if status != http.StatusOK {
+			str := "循环执行失败：" + task.Control.Name + "，第" + strconv.Itoa(i) + "次"
+			logger.LogS().Errorln(stack.BaseString, str)
+			return result, status
+		}
+		if isNormalMode(task) && len(task.Control.ResultKey) > 0 {
+			stack.Heap.Set(task.Control.ResultKey, result)
+		}
+
+		cond, err := util.GetParameterStringValue(stack, nil, &task.Control.Key)
+		if err != nil {
+			str := "计算循环条件失败：" + task.Control.Name + "，" + err.Error()
+			logger.LogS().Errorln(stack.BaseString, str)
+			return util.ErrorResult(hub.TmsErrorCoreId, http.StatusInternalServerError, str, err)
+		}
+		if util.IsConditionTrue(cond) == until {
+			logger.LogS().Infoln(stack.BaseString, "循环结束：", task.Control.Name, " 次数:", i+1)
+			return result, http.StatusOK
+		}
+
+		if i+1 < task.Control.MaxIteration && !sleepWithContext(stack, task.Control.Delay) {
+			cancelResult, code, _ := checkContext(stack, task.Control.Name)
+			return cancelResult, code
+		}
+	}
+
+	str := "超过最大循环次数：" + task.Control.Name + "，" + strconv.Itoa(task.Control.MaxIteration)
+	logger.LogS().Errorln(stack.BaseString, str)
+	return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
+}
+
 func handleApiTask(stack *hub.Stack, task *hub.ScheduleApiDef) (result interface{}, status int) {
-	// 执行API
-	result, status = ApiRun(stack, task.Api, task.Private, false)
+	run, skipResult, status := checkWhen(stack, task.Api)
+	if status != http.StatusOK {
+		return skipResult, status
+	}
+
+	// 执行API，task上的retry代替api上的retry，不会叠加；onError只在重试结束后执行一次
+	if run {
+		apiDef := task.Api
+		if task.Retry != nil {
+			retryDef := *task.Api
+			retryDef.Retry = task.Retry
+			apiDef = &retryDef
+		}
+		result, status = ApiRun(stack, apiDef, task.Private, false)
+		if status != http.StatusOK {
+			result, status = handleStepError(stack, task.Api, task.Private, result, status)
+		}
+	} else {
+		result = skipResult
+	}
 
 	if isNormalMode(task) && len(task.Api.ResultKey) > 0 {
-		stack.Heap[task.Api.ResultKey] = result
+		stack.Heap.Set(task.Api.ResultKey, result)
 	}
 	return
 }
 
+func getScheduleTaskName(task *hub.ScheduleApiDef) string {
+	if task.Api != nil {
+		return task.Api.Name
+	}
+	if task.Control != nil {
+		return task.Control.Name
+	}
+	return task.Type
+}
+
 func handleOneScheduleApi(stack *hub.Stack, task *hub.ScheduleApiDef) (result interface{}, status int) {
+	//api的重试在ApiRun中执行，不包括onError
+	if task.Type == "api" {
+		return runOneScheduleApi(stack, task)
+	}
+	result, status, _ = runWithRetry(stack, getScheduleTaskName(task), task.Retry, func() (interface{}, int) {
+		return runOneScheduleApi(stack, task)
+	})
+	return
+}
+
+func runOneScheduleApi(stack *hub.Stack, task *hub.ScheduleApiDef) (result interface{}, status int) {
 	if len(task.Type) > 0 {
 		switch task.Type {
 		case "switch":
@@ -201,6 +440,12 @@ func handleOneScheduleApi(stack *hub.Stack, task *hub.ScheduleApiDef) (result in
 		case hub.HeapLoopName:
 			logger.LogS().Infoln(stack.BaseString, "运行 loop name", task.Control.Name)
 			return handleLoopTask(stack, task)
+		case "foreach":
+			logger.LogS().Infoln(stack.BaseString, "运行 foreach name", task.Control.Name)
+			return handleForeachTask(stack, task)
+		case "while", "until":
+			logger.LogS().Infoln(stack.BaseString, "运行", task.Type, "name", task.Control.Name)
+			return handleWhileTask(stack, task, task.Type == "until")
 		case "api":
 			logger.LogS().Infoln(stack.BaseString, "运行 api name", task.Api.Name)
 			result, status = handleApiTask(stack, task)
@@ -216,44 +461,103 @@ func handleOneScheduleApi(stack *hub.Stack, task *hub.ScheduleApiDef) (result in
 func concurrentScheWorker(apis chan concurrentScheIn, out chan concurrentScheOut) {
 	for task := range apis {
 		logger.LogS().Infoln(task.stack.BaseString, "并行运行 type：", task.task.Type)
-		result, _ := handleOneScheduleApi(task.stack, task.task)
-		out <- concurrentScheOut{task: task.task, result: result}
+		result, status := handleOneScheduleApi(task.stack, task.task)
+		out <- concurrentScheOut{index: task.index, task: task.task, result: result, status: status}
 	}
 }
 
-func waitConcurrentScheResult(stack *hub.Stack, out chan concurrentScheOut, counter int) interface{} {
-	results := make(map[string]interface{}, counter)
-	var lastKey string
-	for counter > 0 {
+func getScheduleResultKey(task *hub.ScheduleApiDef) string {
+	switch task.Type {
+	case "api":
+		return task.Api.ResultKey
+	default:
+		return task.Control.ResultKey
+	}
+}
+
+// 等待一组并行task结束，按照joinMode决定结果：
+// waitAll(默认)等待所有task，failFast在第一个失败时取消其他task，firstSuccess在第一个成功时取消其他task。
+// 返回结果中results按resultKey保存各task的结果，branches按定义顺序保存各task的code和结果。
+func waitConcurrentScheResult(stack *hub.Stack, out chan concurrentScheOut, counter int, joinMode string, cancel context.CancelFunc, saga *sagaLog) (interface{}, int) {
+	outs := make([]concurrentScheOut, counter)
+	failed, winner := -1, -1
+	for received := 0; received < counter; received++ {
 		//等待结果
 		result := <-out
-		var key string
-		switch result.task.Type {
-		case "api":
-			key = result.task.Api.ResultKey
-		default:
-			key = result.task.Control.ResultKey
+		outs[result.index] = result
+		logger.LogS().Infoln(stack.BaseString, "并行处理结束：", counter-received, " code:", result.status, " result:", result.result)
+
+		switch joinMode {
+		case "failFast":
+			if result.status != http.StatusOK && failed < 0 {
+				failed = result.index
+				cancel()
+			}
+		case "firstSuccess":
+			if result.status == http.StatusOK && winner < 0 {
+				winner = result.index
+				cancel()
+			}
 		}
-		logger.LogS().Infoln(stack.BaseString, "并行处理结束：", counter, " result:", result)
-		if len(key) > 0 {
-			results[key] = result.result
-			lastKey = key
+	}
+	cancel()
+
+	status := http.StatusOK
+	results := make(map[string]interface{}, counter)
+	branches := make([]interface{}, counter)
+	for i := range outs {
+		key := getScheduleResultKey(outs[i].task)
+		branches[i] = map[string]interface{}{"index": i, "resultKey": key, "code": outs[i].status, "result": outs[i].result}
+		//failFast时为最先返回的失败，其他情况按照定义顺序取第一个失败
+		if outs[i].status != http.StatusOK && failed < 0 {
+			failed = i
+		}
+		if outs[i].status == http.StatusOK {
+			saga.addScheduleTask(outs[i].task)
+		}
+
+		//防止并发读写crash，所有task结束后再写入heap
+		if len(key) > 0 && (joinMode != "firstSuccess" || i == winner) {
+			results[key] = outs[i].result
+			stack.Heap.Set(key, outs[i].result)
 		}
-		counter--
 	}
-	//防止并发读写crash
-	for k, v := range results {
-		stack.Heap[k] = v
+
+	groupResult := map[string]interface{}{"results": results, "branches": branches}
+	if joinMode == "firstSuccess" && winner >= 0 {
+		groupResult["winner"] = winner
+	} else if failed >= 0 {
+		status = outs[failed].status
 	}
+	return groupResult, status
+}
+
+// 并行task组的context，在waitConcurrentScheResult中取消
+func newGroupContext(stack *hub.Stack) (context.Context, context.CancelFunc) {
+	return context.WithCancel(util.GetContext(stack))
+}
 
-	//由于并行，最后的结果并不确定，所以并行的返回结果不是固定的，因此当需要返回值时，最后一个应该是非并行的
-	return results[lastKey]
+func handleTasks(stack *hub.Stack, apis *[]hub.ScheduleApiDef, concurrentNum int, joinMode string) (result interface{}, status int) {
+	return handleTasksWithSaga(stack, apis, concurrentNum, joinMode, nil)
 }
 
-func handleTasks(stack *hub.Stack, apis *[]hub.ScheduleApiDef, concurrentNum int) (result interface{}, status int) {
+// 成功的task记录到saga中，schedule失败时执行compensate。
+// 有saga时（schedule定义了onError或者compensate），串行task或者并行task组失败时立即返回，不再执行后续task；
+// 没有saga时和原来一样继续执行后续task。
+func handleTasksWithSaga(stack *hub.Stack, apis *[]hub.ScheduleApiDef, concurrentNum int, joinMode string, saga *sagaLog) (result interface{}, status int) {
 	var counter int
 	var in chan concurrentScheIn
 	var out chan concurrentScheOut
+	var groupCtx context.Context
+	var cancel context.CancelFunc
+	if apis == nil {
+		str := "apis nil"
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
+	}
+	if util.IsScheduleDag(*apis) {
+		return handleDagTasks(stack, apis, concurrentNum, joinMode, saga)
+	}
 	if concurrentNum > 1 {
 		/*假设所有的task都是并行的，多留buffer，提升性能*/
 		in = make(chan concurrentScheIn, len(*apis))
@@ -265,45 +569,76 @@ func handleTasks(stack *hub.Stack, apis *[]hub.ScheduleApiDef, concurrentNum int
 			go concurrentScheWorker(in, out)
 		}
 	}
-	if apis == nil {
-		str := "apis nil"
-		logger.LogS().Errorln(stack.BaseString, str)
-		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
-	}
 	logger.LogS().Infoln(stack.BaseString, "apis lens：", len(*apis))
 	for index := range *apis {
 		task := &(*apis)[index]
+		if cancelResult, code, ok := checkContext(stack, getScheduleTaskName(task)); !ok {
+			if counter > 0 {
+				waitConcurrentScheResult(stack, out, counter, joinMode, cancel, saga)
+			}
+			return cancelResult, code
+		}
 		if concurrentNum > 1 {
 			if task.Mode == "concurrent" { //多个不同任务
 				logger.LogS().Infoln(stack.BaseString, "准备并行运行 type：", task.Type, ",concurrentNum:", concurrentNum)
+				if counter == 0 {
+					//每组并行task使用独立的context，便于failFast和firstSuccess时取消
+					groupCtx, cancel = newGroupContext(stack)
+				}
 				tmpStack := copyScheduleStack(stack, task)
-				in <- concurrentScheIn{stack: tmpStack, task: task}
+				tmpStack.Context = groupCtx
+				in <- concurrentScheIn{index: counter, stack: tmpStack, task: task}
 				counter++
 				continue
 			} else {
 				//避免并发读写ResultKey
 				if counter > 0 {
-					result = waitConcurrentScheResult(stack, out, counter)
+					result, status = waitConcurrentScheResult(stack, out, counter, joinMode, cancel, saga)
 					counter = 0
+					if status != http.StatusOK && saga != nil {
+						logger.LogS().Errorln(stack.BaseString, "并行task失败，不再执行后续task code:", status)
+						return result, status
+					}
 				}
 			}
 		}
 		if task.Mode == "background" {
 			logger.LogS().Infoln(stack.BaseString, "后台 type：", task.Type)
-			go handleOneScheduleApi(stack, task)
+			//后台任务在独立的stack中运行，不随请求结束而取消，job id保存在.job.name中
+			hub.SetHeapMapValue(stack.Heap, hub.HeapJobName, getScheduleTaskName(task), startBackgroundJob(stack, task))
 		} else { //串行steps
 			logger.LogS().Infoln(stack.BaseString, "串行 type：", task.Type, ", concurrentNum:", concurrentNum)
 			result, status = handleOneScheduleApi(stack, task)
+			if status != http.StatusOK && saga != nil {
+				logger.LogS().Errorln(stack.BaseString, "串行task失败，不再执行后续task：", getScheduleTaskName(task), " code:", status)
+				return result, status
+			}
+			if status == http.StatusOK {
+				saga.addScheduleTask(task)
+			}
 		}
 	}
 
 	//防止都是并行任务
 	if counter > 0 {
-		result = waitConcurrentScheResult(stack, out, counter)
+		result, status = waitConcurrentScheResult(stack, out, counter, joinMode, cancel, saga)
 	}
 	return result, status
 }
 
+// 定义了onError或者compensate时，task失败后不再执行后续task
+func isScheduleSaga(scheduleDef *hub.ScheduleDef) bool {
+	if scheduleDef.OnError != nil {
+		return true
+	}
+	for i := range *scheduleDef.Steps {
+		if (*scheduleDef.Steps)[i].Compensate != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func runSchedule(stack *hub.Stack, name string, private string) (interface{}, int) {
 	scheduleDef, ok := util.FindScheduleDef(name)
 	if !ok || scheduleDef == nil || scheduleDef.Steps == nil {
@@ -311,9 +646,43 @@ func runSchedule(stack *hub.Stack, name string, private string) (interface{}, in
 		logger.LogS().Errorln(stack.BaseString, str)
 		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
 	}
-	stack.Heap[hub.HeapLoopName] = make(map[string]int)
+	stack.Heap.Set(hub.HeapLoopName, make(map[string]int))
 
-	return handleTasks(stack, scheduleDef.Steps, scheduleDef.ConcurrentNum)
+	defer enterNested(stack)()
+	restore := withTimeout(stack, scheduleDef.Timeout)
+	defer restore()
+
+	if scheduleDef.Finally != nil {
+		defer runScheduleFinally(stack, name, scheduleDef)
+	}
+
+	var saga *sagaLog
+	if isScheduleSaga(scheduleDef) {
+		saga = &sagaLog{}
+	}
+	result, status := handleTasksWithSaga(stack, scheduleDef.Steps, scheduleDef.ConcurrentNum, scheduleDef.JoinMode, saga)
+	if status != http.StatusOK && scheduleDef.OnError != nil {
+		logger.LogS().Infoln(stack.BaseString, "运行Schedule：", name, "失败，执行onError")
+		//onError不受schedule自身超时的影响
+		restore()
+		result, status = handleTasks(stack, scheduleDef.OnError, scheduleDef.ConcurrentNum, scheduleDef.JoinMode)
+	}
+	if status != http.StatusOK {
+		result = saga.compensate(stack, name, result)
+	}
+	return result, status
+}
+
+// finally中的task总会执行，不受超时和取消的影响，结果只记录日志
+func runScheduleFinally(stack *hub.Stack, name string, scheduleDef *hub.ScheduleDef) {
+	ctx := stack.Context
+	stack.Context = context.Background()
+	defer func() { stack.Context = ctx }()
+
+	result, status := handleTasks(stack, scheduleDef.Finally, scheduleDef.ConcurrentNum, scheduleDef.JoinMode)
+	if status != http.StatusOK {
+		logger.LogS().Errorln(stack.BaseString, "运行Schedule：", name, "finally失败 result:", result)
+	}
 }
 
 func runScheduleApi(stack *hub.Stack, params map[string]string) (interface{}, int) {
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func TestScheduleStopsAtFailedStepAndCompensates(t *testing.T) {
+	resetTestCalls()
+	a := newTestTask("a", http.StatusOK)
+	a.Compensate = &[]hub.ScheduleApiDef{newTestTask("undo-a", http.StatusOK)}
+	b := newTestTask("b", http.StatusOK)
+	b.Compensate = &[]hub.ScheduleApiDef{newTestTask("undo-b", http.StatusOK)}
+	addTestSchedule(t, &hub.ScheduleDef{Name: "test-saga", Steps: &[]hub.ScheduleApiDef{
+		a, b, newTestTask("fail", http.StatusBadGateway), newTestTask("c", http.StatusOK),
+	}})
+
+	result, status := runSchedule(newTestStack(), "test-saga", "")
+	if status != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d, result: %v", status, http.StatusBadGateway, result)
+	}
+	checkTestCalls(t, "a", "b", "fail", "undo-b", "undo-a")
+
+	outcomes, ok := result.(map[string]interface{})["compensate"].([]interface{})
+	if !ok || len(outcomes) != 2 {
+		t.Fatalf("compensate = %v", result)
+	}
+}
+
+func TestScheduleSucceeds(t *testing.T) {
+	resetTestCalls()
+	addTestSchedule(t, &hub.ScheduleDef{Name: "test-ok", Steps: &[]hub.ScheduleApiDef{
+		newTestTask("a", http.StatusOK), newTestTask("b", http.StatusOK),
+	}})
+
+	result, status := runSchedule(newTestStack(), "test-ok", "")
+	if status != http.StatusOK || result != "b" {
+		t.Fatalf("status = %d, result = %v", status, result)
+	}
+	checkTestCalls(t, "a", "b")
+}
+
+func TestScheduleOnErrorAfterMiddleStepFails(t *testing.T) {
+	resetTestCalls()
+	addTestSchedule(t, &hub.ScheduleDef{Name: "test-onerror",
+		Steps:   &[]hub.ScheduleApiDef{newTestTask("a", http.StatusOK), newTestTask("fail", http.StatusInternalServerError), newTestTask("c", http.StatusOK)},
+		OnError: &[]hub.ScheduleApiDef{newTestTask("recover", http.StatusOK)},
+		Finally: &[]hub.ScheduleApiDef{newTestTask("finally", http.StatusOK)},
+	})
+
+	result, status := runSchedule(newTestStack(), "test-onerror", "")
+	if status != http.StatusOK || result != "recover" {
+		t.Fatalf("status = %d, result = %v", status, result)
+	}
+	checkTestCalls(t, "a", "fail", "recover", "finally")
+}
+
+func TestScheduleStopsAfterFailedConcurrentGroup(t *testing.T) {
+	for _, joinMode := range []string{"", "failFast"} {
+		resetTestCalls()
+		a := newTestTask("a", http.StatusOK)
+		a.Mode = "concurrent"
+		a.Compensate = &[]hub.ScheduleApiDef{newTestTask("undo-a", http.StatusOK)}
+		fail := newTestTask("fail", http.StatusBadGateway)
+		fail.Mode = "concurrent"
+		addTestSchedule(t, &hub.ScheduleDef{Name: "test-group", ConcurrentNum: 2, JoinMode: joinMode,
+			Steps: &[]hub.ScheduleApiDef{a, fail, newTestTask("c", http.StatusOK)},
+		})
+
+		_, status := runSchedule(newTestStack(), "test-group", "")
+		if status != http.StatusBadGateway {
+			t.Fatalf("joinMode %q: status = %d", joinMode, status)
+		}
+		for _, name := range getTestCalls() {
+			if name == "c" {
+				t.Fatalf("joinMode %q: step after failed group ran", joinMode)
+			}
+		}
+	}
+}
+
+// 没有定义onError和compensate时，失败后继续执行后续task
+func TestScheduleWithoutSagaContinuesAfterFailure(t *testing.T) {
+	resetTestCalls()
+	a := newTestTask("a", http.StatusOK)
+	a.Mode = "concurrent"
+	fail := newTestTask("fail", http.StatusBadGateway)
+	fail.Mode = "concurrent"
+	addTestSchedule(t, &hub.ScheduleDef{Name: "test-continue", ConcurrentNum: 2,
+		Steps: &[]hub.ScheduleApiDef{newTestTask("b", http.StatusInternalServerError), a, fail, newTestTask("c", http.StatusOK)},
+	})
+
+	result, status := runSchedule(newTestStack(), "test-continue", "")
+	if status != http.StatusOK || result != "c" {
+		t.Fatalf("status = %d, result = %v", status, result)
+	}
+	if calls := getTestCalls(); len(calls) != 4 || calls[0] != "b" || calls[3] != "c" {
+		t.Fatalf("calls = %v", calls)
+	}
+}
+
+func TestScheduleTaskRetryRunsOnErrorOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiRetry *hub.RetryDef
+	}{
+		{"task retry", nil},
+		{"task retry replaces api retry", &hub.RetryDef{MaxAttempts: 2, Interval: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestCalls()
+			task := newTestTask("fail", http.StatusBadGateway)
+			task.Retry = &hub.RetryDef{MaxAttempts: 3, Interval: 1}
+			task.Api.Retry = tt.apiRetry
+			recover := newTestApi("recover", http.StatusOK)
+			recover.ResultKey = "recover"
+			task.Api.OnError = []hub.ApiDef{*recover}
+			addTestSchedule(t, &hub.ScheduleDef{Name: "test-retry", Steps: &[]hub.ScheduleApiDef{task}})
+
+			result, status := runSchedule(newTestStack(), "test-retry", "")
+			if status != http.StatusOK || result != "recover" {
+				t.Fatalf("status = %d, result = %v", status, result)
+			}
+			checkTestCalls(t, "fail", "fail", "fail", "recover")
+		})
+	}
+}
+
+func TestScheduleForeachFromOrigin(t *testing.T) {
+	resetTestCalls()
+	step := newTestApi("{{.foreach.items.value.id}}", http.StatusOK)
+	(*step.Args)[0].Value.From = "template"
+	addTestSchedule(t, &hub.ScheduleDef{Name: "test-foreach", Steps: &[]hub.ScheduleApiDef{{Type: "foreach",
+		Control: &hub.ScheduleControlDef{Name: "items", Key: hub.BaseValueDef{From: "origin", Content: "items"},
+			Steps: &[]hub.ScheduleApiDef{{Type: "api", Api: step}}},
+	}}})
+
+	stack := newTestStack()
+	stack.Heap.Set(hub.HeapOriginName, map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"id": "a"}, map[string]interface{}{"id": "b"}},
+	})
+	if result, status := runSchedule(stack, "test-foreach", ""); status != http.StatusOK {
+		t.Fatalf("status = %d, result = %v", status, result)
+	}
+	checkTestCalls(t, "a", "b")
+}
+
+// firstSuccess时所有task都失败，和waitAll一样返回定义顺序中第一个失败的状态码
+func TestScheduleFirstSuccessAllFailed(t *testing.T) {
+	for _, joinMode := range []string{"", "firstSuccess"} {
+		resetTestCalls()
+		a := newTestTask("a", http.StatusBadGateway)
+		a.Mode = "concurrent"
+		b := newTestTask("b", http.StatusInternalServerError)
+		b.Mode = "concurrent"
+		addTestSchedule(t, &hub.ScheduleDef{Name: "test-all-failed", ConcurrentNum: 2, JoinMode: joinMode,
+			Steps: &[]hub.ScheduleApiDef{a, b},
+		})
+
+		result, status := runSchedule(newTestStack(), "test-all-failed", "")
+		if status != http.StatusBadGateway {
+			t.Fatalf("joinMode %q: status = %d, result = %v", joinMode, status, result)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+// flowApi自身的参数，不作为input的值
+var flowApiReservedParams = map[string]bool{"name": true, "private": true}
+
+// 标记stack在flow或者schedule中执行，返回恢复的函数
+func enterNested(stack *hub.Stack) func() {
+	nested := stack.Nested
+	stack.Nested = true
+	return func() { stack.Nested = nested }
+}
+
+// 只有flow或者schedule中调用的flowApi才会隔离，apiGateway直接调用的flow总是使用请求的heap。
+// 未指定scope时，定义了inputs或outputs的flow在独立的heap中运行
+func isFlowIsolated(stack *hub.Stack, flowDef *hub.FlowDef) bool {
+	if !stack.Nested {
+		return false
+	}
+	switch flowDef.Scope {
+	case hub.FlowScopeIsolated:
+		return true
+	case hub.FlowScopeShared:
+		return false
+	default:
+		return len(flowDef.Inputs) > 0 || len(flowDef.Outputs) > 0
+	}
+}
+
+func convertFlowInput(input *hub.FlowInputDef, value interface{}) (interface{}, error) {
+	str, isString := value.(string)
+	switch input.Type {
+	case "", "string":
+		if isString {
+			return str, nil
+		}
+		byteJson, err := json.Marshal(value)
+		return string(byteJson), err
+	case "number":
+		if isString {
+			return strconv.ParseFloat(str, 64)
+		}
+		return value, nil
+	case "bool":
+		if isString {
+			return strconv.ParseBool(str)
+		}
+		return value, nil
+	case "json":
+		if isString {
+			var result interface{}
+			err := json.Unmarshal([]byte(str), &result)
+			return result, err
+		}
+		return value, nil
+	default:
+		return nil, errors.New("不支持的input类型：" + input.Type)
+	}
+}
+
+// 按照flow的inputs创建独立的stack，input的值依次来自flowApi的参数（name和private除外）、调用者的origin和default
+func newFlowScope(stack *hub.Stack, flowDef *hub.FlowDef, params map[string]string) (*hub.Stack, interface{}, int) {
+	callerOrigin := hub.GetHeapMap[interface{}](stack.Heap, hub.HeapOriginName)
+	origin := make(map[string]interface{}, len(flowDef.Inputs))
+	for i := range flowDef.Inputs {
+		input := &flowDef.Inputs[i]
+		var value interface{}
+		if param, ok := params[input.Name]; ok && !flowApiReservedParams[input.Name] {
+			value = param
+		} else if param, ok := callerOrigin[input.Name]; ok {
+			value = param
+		} else if input.Default != nil {
+			origin[input.Name] = input.Default
+			continue
+		} else if input.Required {
+			str := "运行Flow：" + flowDef.Name + "缺少input：" + input.Name
+			logger.LogS().Errorln(stack.BaseString, str)
+			return nil, util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusBadRequest
+		} else {
+			continue
+		}
+
+		converted, err := convertFlowInput(input, value)
+		if err != nil {
+			str := "运行Flow：" + flowDef.Name + "的input：" + input.Name + "无效，" + err.Error()
+			logger.LogS().Errorln(stack.BaseString, str)
+			return nil, util.CreateTmsError(hub.TmsErrorCoreId, str, err), http.StatusBadRequest
+		}
+		origin[input.Name] = converted
+	}
+
+	//base通过copy-on-write修改，子flow修改base不影响调用者
+	base, _ := stack.Heap.Get(hub.HeapBaseName)
+
+	return &hub.Stack{
+		GinContext: stack.GinContext,
+		Context:    stack.Context,
+		Heap:       hub.NewHeap(map[string]interface{}{hub.HeapOriginName: origin, hub.HeapBaseName: base}),
+		BaseString: stack.BaseString,
+		StartTime:  stack.StartTime,
+		Trace:      stack.Trace,
+		DryRun:     stack.DryRun,
+		Nested:     true,
+	}, nil, http.StatusOK
+}
+
+// 按照flow的outputs从子flow的heap中取值，没有定义value时取heap中同名的值
+func getFlowOutputs(stack *hub.Stack, flowDef *hub.FlowDef) (interface{}, int) {
+	outputs := make(map[string]interface{}, len(flowDef.Outputs))
+	for i := range flowDef.Outputs {
+		output := &flowDef.Outputs[i]
+		var value interface{}
+		var err error
+		if output.Value == nil {
+			value, err = util.GetHeapRawValue(stack, output.Name)
+		} else {
+			value, err = util.GetParameterRawValue(stack, nil, output.Value)
+		}
+		if err != nil {
+			str := "获得Flow：" + flowDef.Name + "的output：" + output.Name + "失败，" + err.Error()
+			logger.LogS().Errorln(stack.BaseString, str)
+			return util.ErrorResult(hub.TmsErrorCoreId, http.StatusInternalServerError, str, err)
+		}
+		outputs[output.Name] = value
+	}
+	return outputs, http.StatusOK
+}
+
+// 在独立的heap中运行flow，子flow的resultKey不会影响调用者
+func runIsolatedFlow(stack *hub.Stack, flowDef *hub.FlowDef, name string, private string, params map[string]string) (interface{}, int) {
+	scope, result, code := newFlowScope(stack, flowDef, params)
+	if code != http.StatusOK {
+		return result, code
+	}
+
+	result, code = runFlow(scope, name, private)
+	if code != http.StatusOK || len(flowDef.Outputs) == 0 {
+		return result, code
+	}
+	return getFlowOutputs(scope, flowDef)
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+func init() {
+	RegisterApis(map[string]hub.ApiHandler{
+		"scopeTestEcho": func(stack *hub.Stack, params map[string]string) (interface{}, int) {
+			return params["value"], http.StatusOK
+		},
+	})
+}
+
+func addScopeTestFlow(t *testing.T, def *hub.FlowDef) {
+	util.DefaultConfMap.FlowMap[def.Name] = def
+	t.Cleanup(func() { delete(util.DefaultConfMap.FlowMap, def.Name) })
+}
+
+func newScopeTestStep(resultKey string, template string) hub.ApiDef {
+	args := []hub.BaseParamDef{{Name: "value", Value: hub.BaseValueDef{From: "template", Content: template}}}
+	return hub.ApiDef{Name: resultKey, Command: "scopeTestEcho", ResultKey: resultKey, Args: &args}
+}
+
+func newFlowApiStep(resultKey string, name string, params map[string]string) hub.ApiDef {
+	args := []hub.BaseParamDef{{Name: "name", Value: hub.BaseValueDef{From: "literal", Content: name}}}
+	for k, v := range params {
+		args = append(args, hub.BaseParamDef{Name: k, Value: hub.BaseValueDef{From: "literal", Content: v}})
+	}
+	return hub.ApiDef{Name: resultKey, Command: "flowApi", ResultKey: resultKey, Args: &args}
+}
+
+func newScopeTestStack(origin map[string]interface{}) *hub.Stack {
+	return &hub.Stack{Context: context.Background(), Heap: hub.NewHeap(map[string]interface{}{hub.HeapOriginName: origin})}
+}
+
+func TestConvertFlowInput(t *testing.T) {
+	tests := []struct {
+		inputType string
+		value     interface{}
+		want      interface{}
+		err       bool
+	}{
+		{"", "a", "a", false},
+		{"string", map[string]interface{}{"a": 1.0}, `{"a":1}`, false},
+		{"number", "1.5", 1.5, false},
+		{"number", 2.0, 2.0, false},
+		{"number", "x", nil, true},
+		{"bool", "true", true, false},
+		{"bool", "x", nil, true},
+		{"json", `{"a":"b"}`, "b", false},
+		{"json", "{", nil, true},
+		{"date", "a", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := convertFlowInput(&hub.FlowInputDef{Name: "in", Type: tt.inputType}, tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("%s %v: err = %v", tt.inputType, tt.value, err)
+			continue
+		}
+		if m, ok := got.(map[string]interface{}); ok {
+			got = m["a"]
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("%s %v: got %v, want %v", tt.inputType, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewFlowScopeInputs(t *testing.T) {
+	flowDef := &hub.FlowDef{Name: "child", Inputs: []hub.FlowInputDef{
+		{Name: "city", Required: true},
+		{Name: "days", Type: "number", Default: 3.0},
+		{Name: "name"},
+		{Name: "private", Default: "default"},
+	}}
+	tests := []struct {
+		name   string
+		params map[string]string
+		origin map[string]interface{}
+		code   int
+		want   map[string]interface{}
+	}{
+		{"params", map[string]string{"name": "child", "city": "a", "days": "5"}, nil, http.StatusOK,
+			map[string]interface{}{"city": "a", "days": 5.0}},
+		{"caller origin and default", map[string]string{"name": "child", "private": "key"}, map[string]interface{}{"city": "b", "name": "origin-name"}, http.StatusOK,
+			map[string]interface{}{"city": "b", "days": 3.0, "name": "origin-name", "private": "default"}},
+		{"empty param", map[string]string{"name": "child", "city": ""}, nil, http.StatusOK, map[string]interface{}{"city": ""}},
+		{"missing required", map[string]string{"name": "child"}, nil, http.StatusBadRequest, nil},
+		{"invalid type", map[string]string{"name": "child", "city": "a", "days": "x"}, nil, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, result, code := newFlowScope(newScopeTestStack(tt.origin), flowDef, tt.params)
+			if code != tt.code {
+				t.Fatalf("code = %d, result = %v", code, result)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			origin := hub.GetHeapMap[interface{}](scope.Heap, hub.HeapOriginName)
+			for k, v := range tt.want {
+				if origin[k] != v {
+					t.Fatalf("origin = %v, want %v", origin, tt.want)
+				}
+			}
+			if _, ok := origin["name"]; ok && tt.want["name"] == nil {
+				t.Fatalf("reserved param used as input: %v", origin)
+			}
+		})
+	}
+}
+
+func TestFlowApiScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    string
+		inputs   []hub.FlowInputDef
+		isolated bool
+		child    string
+	}{
+		{"inputs", "", []hub.FlowInputDef{{Name: "city"}}, true, "param"},
+		{"shared with inputs", hub.FlowScopeShared, []hub.FlowInputDef{{Name: "city"}}, false, "origin"},
+		{"no inputs", "", nil, false, "origin"},
+		{"isolated without inputs", hub.FlowScopeIsolated, nil, true, "<no value>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addScopeTestFlow(t, &hub.FlowDef{Name: "child", Scope: tt.scope, Inputs: tt.inputs,
+				Steps: []hub.ApiDef{newScopeTestStep("inner", "{{.origin.city}}")}})
+			addScopeTestFlow(t, &hub.FlowDef{Name: "parent",
+				Steps: []hub.ApiDef{newFlowApiStep("child", "child", map[string]string{"city": "param"})}})
+
+			//apiGateway直接调用parent，parent中的flowApi调用child
+			stack := newScopeTestStack(map[string]interface{}{"city": "origin"})
+			args := []hub.BaseParamDef{{Name: "name", Value: hub.BaseValueDef{From: "literal", Content: "parent"}}}
+			if result, code := ApiRun(stack, &hub.ApiDef{Name: "main", Command: "flowApi", Args: &args}, "", false); code != http.StatusOK {
+				t.Fatalf("code = %d, result = %v", code, result)
+			}
+
+			inner, ok := stack.Heap.Get("inner")
+			if ok == tt.isolated {
+				t.Fatalf("inner = %v, isolated = %v", inner, tt.isolated)
+			}
+			child, _ := stack.Heap.Get("child")
+			if child != tt.child {
+				t.Fatalf("child result = %v", child)
+			}
+			if stack.Nested {
+				t.Fatal("stack still nested after the flow")
+			}
+		})
+	}
+}
+
+// apiGateway直接调用定义了inputs的flow时不隔离，resultKey对postOK等flow可见
+func TestTopLevelFlowNotIsolated(t *testing.T) {
+	addScopeTestFlow(t, &hub.FlowDef{Name: "top", Inputs: []hub.FlowInputDef{{Name: "city"}},
+		Steps: []hub.ApiDef{newScopeTestStep("weather", "{{.origin.city}}")}})
+
+	stack := newScopeTestStack(map[string]interface{}{"city": "origin"})
+	args := []hub.BaseParamDef{{Name: "name", Value: hub.BaseValueDef{From: "literal", Content: "top"}}}
+	if _, code := ApiRun(stack, &hub.ApiDef{Name: "main", Command: "flowApi", Args: &args}, "", false); code != http.StatusOK {
+		t.Fatalf("code = %d", code)
+	}
+	if weather, _ := stack.Heap.Get("weather"); weather != "origin" {
+		t.Fatalf("weather = %v", weather)
+	}
+}
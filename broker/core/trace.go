@@ -0,0 +1,201 @@
+package core
+
+import (
+	"encoding/json"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+const defaultTraceSize = 100
+const traceMask = "******"
+
+// 参数名称包含这些关键字时，记录trace时隐藏参数值
+var traceSecretRegexp = regexp.MustCompile(`(?i)secret|token|passw|pwd|auth|credential|signature|(api|app|access|private)[_-]?key`)
+
+type traceRecord struct {
+	ids  []string
+	root *hub.TraceNode
+}
+
+// trace按照完成的顺序保存在环形缓冲中，超过容量时覆盖最早的记录
+var traceLock sync.Mutex
+var traceRing = make([]*traceRecord, defaultTraceSize)
+var traceNext int
+var traceIndex = make(map[string]*traceRecord)
+
+func SetTraceSize(size int) {
+	if size <= 0 {
+		size = defaultTraceSize
+	}
+
+	traceLock.Lock()
+	defer traceLock.Unlock()
+
+	traceRing = make([]*traceRecord, size)
+	traceNext = 0
+	traceIndex = make(map[string]*traceRecord)
+}
+
+// 开始记录stack上的所有ApiRun
+func StartTrace(stack *hub.Stack, command string, name string) {
+	stack.Trace = &hub.TraceNode{Command: command, Name: name, Start: time.Now()}
+}
+
+// 结束记录并保存到环形缓冲，ids中的每一个都可以用来查询
+func FinishTrace(stack *hub.Stack, code int, ids ...string) {
+	root := stack.Trace
+	if root == nil {
+		return
+	}
+	stack.Trace = nil
+
+	traceLock.Lock()
+	defer traceLock.Unlock()
+
+	root.Duration = time.Since(root.Start).Seconds()
+	root.Code = code
+
+	if old := traceRing[traceNext]; old != nil {
+		for _, id := range old.ids {
+			delete(traceIndex, id)
+		}
+	}
+
+	record := &traceRecord{root: root}
+	for _, id := range ids {
+		if len(id) > 0 && traceIndex[id] != record {
+			record.ids = append(record.ids, id)
+			traceIndex[id] = record
+		}
+	}
+	traceRing[traceNext] = record
+	traceNext = (traceNext + 1) % len(traceRing)
+}
+
+// 返回trace的json，后台任务可能仍在写入，所以在锁内序列化
+func GetTrace(id string) (json.RawMessage, bool) {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+
+	record, ok := traceIndex[id]
+	if !ok {
+		return nil, false
+	}
+	byteJson, err := json.Marshal(record.root)
+	if err != nil {
+		return nil, false
+	}
+	return byteJson, true
+}
+
+// 按照完成顺序返回trace的概要
+func ListTraces() []interface{} {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+
+	result := make([]interface{}, 0, len(traceRing))
+	for i := range traceRing {
+		record := traceRing[(traceNext+i)%len(traceRing)]
+		if record == nil {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"ids":      record.ids,
+			"command":  record.root.Command,
+			"name":     record.root.Name,
+			"start":    record.root.Start.Format(time.RFC3339),
+			"duration": record.root.Duration,
+			"code":     record.root.Code,
+		})
+	}
+	return result
+}
+
+// 在当前节点下添加子节点，并作为stack的当前节点，返回恢复函数
+func enterTrace(stack *hub.Stack, api *hub.ApiDef) (*hub.TraceNode, func()) {
+	parent := stack.Trace
+	if parent == nil {
+		return nil, func() {}
+	}
+
+	node := &hub.TraceNode{Command: api.Command, Name: api.Name, Start: time.Now()}
+	traceLock.Lock()
+	parent.Children = append(parent.Children, node)
+	traceLock.Unlock()
+
+	stack.Trace = node
+	return node, func() { stack.Trace = parent }
+}
+
+func finishTraceNode(node *hub.TraceNode, result interface{}, code int, attempts int) {
+	if node == nil {
+		return
+	}
+
+	size := getResultSize(result)
+	traceLock.Lock()
+	defer traceLock.Unlock()
+
+	node.Duration = time.Since(node.Start).Seconds()
+	node.Code = code
+	node.Attempts = attempts
+	node.ResultSize = size
+	if tmsErr, ok := result.(hub.TmsError); ok {
+		node.Error = tmsErr.ErrorMsg
+	}
+}
+
+// 记录解析后的参数，来自private或者名称敏感的参数值被隐藏
+func setTraceArgs(stack *hub.Stack, api *hub.ApiDef, args map[string]string) {
+	node := stack.Trace
+	if node == nil {
+		return
+	}
+
+	private := make(map[string]bool)
+	if api.Args != nil {
+		for _, item := range *api.Args {
+			if item.Value.From == "private" {
+				private[item.Name] = true
+			}
+		}
+	}
+
+	masked := make(map[string]string, len(args))
+	for k, v := range args {
+		if private[k] || IsSecretName(k) {
+			masked[k] = traceMask
+		} else {
+			masked[k] = v
+		}
+	}
+
+	traceLock.Lock()
+	node.Args = masked
+	traceLock.Unlock()
+}
+
+// 名称是否表示敏感的值，例如secret、token、password、auth、apikey
+func IsSecretName(name string) bool {
+	return traceSecretRegexp.MatchString(name)
+}
+
+func getResultSize(result interface{}) int {
+	switch value := result.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(value)
+	case []byte:
+		return len(value)
+	default:
+		byteJson, err := json.Marshal(value)
+		if err != nil {
+			return 0
+		}
+		return len(byteJson)
+	}
+}
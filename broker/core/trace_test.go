@@ -0,0 +1,68 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func TestIsSecretName(t *testing.T) {
+	tests := map[string]bool{
+		"appSecret":     true,
+		"access_token":  true,
+		"Password":      true,
+		"pwd":           true,
+		"Authorization": true,
+		"credentials":   true,
+		"signature":     true,
+		"apikey":        true,
+		"api-key":       true,
+		"APP_KEY":       true,
+		"privateKey":    true,
+		"accessKey":     true,
+		"key":           false,
+		"name":          false,
+		"city":          false,
+		"monkey":        false,
+	}
+	for name, want := range tests {
+		if got := IsSecretName(name); got != want {
+			t.Errorf("IsSecretName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSetTraceArgsMasksSecrets(t *testing.T) {
+	stack := &hub.Stack{Trace: &hub.TraceNode{}}
+	args := []hub.BaseParamDef{
+		{Name: "appid", Value: hub.BaseValueDef{From: "private", Content: "appid"}},
+		{Name: "city", Value: hub.BaseValueDef{From: "literal", Content: "beijing"}},
+	}
+	setTraceArgs(stack, &hub.ApiDef{Args: &args}, map[string]string{"appid": "id", "city": "beijing", "token": "t"})
+
+	want := map[string]string{"appid": traceMask, "city": "beijing", "token": traceMask}
+	for k, v := range want {
+		if stack.Trace.Args[k] != v {
+			t.Errorf("args[%s] = %q, want %q", k, stack.Trace.Args[k], v)
+		}
+	}
+}
+
+func TestTraceRingOverwritesOldest(t *testing.T) {
+	SetTraceSize(2)
+	t.Cleanup(func() { SetTraceSize(0) })
+
+	for _, id := range []string{"trace-1", "trace-2", "trace-3"} {
+		stack := &hub.Stack{}
+		StartTrace(stack, "flow", id)
+		FinishTrace(stack, http.StatusOK, id)
+	}
+	if _, ok := GetTrace("trace-1"); ok {
+		t.Fatal("oldest trace not overwritten")
+	}
+	list := ListTraces()
+	if len(list) != 2 || list[0].(map[string]interface{})["name"] != "trace-2" || list[1].(map[string]interface{})["name"] != "trace-3" {
+		t.Fatalf("traces = %v", list)
+	}
+}
@@ -0,0 +1,433 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+const (
+	TriggerOverlapSkip  = "skip"
+	TriggerOverlapQueue = "queue"
+	TriggerOverlapAllow = "allow"
+)
+
+type triggerRunner struct {
+	def     *hub.TriggerDef
+	cron    *cronSchedule
+	enabled bool
+	stop    chan struct{}
+	//运行状态，由triggerLock保护
+	running      int
+	pending      int
+	runs         int64
+	failures     int64
+	skipped      int64
+	nextRun      time.Time
+	lastStart    time.Time
+	lastDuration float64
+	lastCode     int
+	lastError    string
+}
+
+var triggerLock sync.Mutex
+var triggerMap = make(map[string]*triggerRunner)
+
+func newTriggerRunner(def *hub.TriggerDef) (*triggerRunner, error) {
+	if len(def.Schedule) == 0 {
+		return nil, errors.New("trigger缺少schedule")
+	}
+
+	r := &triggerRunner{def: def, enabled: !def.Disabled}
+	switch {
+	case len(def.Cron) > 0:
+		cron, err := parseCron(def.Cron)
+		if err != nil {
+			return nil, err
+		}
+		r.cron = cron
+	case def.Interval > 0:
+	default:
+		return nil, errors.New("trigger需要配置cron或interval")
+	}
+	return r, nil
+}
+
+func (r *triggerRunner) nextTime(now time.Time) time.Time {
+	if r.cron != nil {
+		return r.cron.next(now)
+	}
+	return now.Add(time.Duration(r.def.Interval) * time.Second)
+}
+
+func (r *triggerRunner) info() map[string]interface{} {
+	info := map[string]interface{}{
+		"name":     r.def.Name,
+		"schedule": r.def.Schedule,
+		"enabled":  r.enabled,
+		"overlap":  r.overlap(),
+		"running":  r.running,
+		"pending":  r.pending,
+		"runs":     r.runs,
+		"failures": r.failures,
+		"skipped":  r.skipped,
+	}
+	if r.cron != nil {
+		info["cron"] = r.def.Cron
+	} else {
+		info["interval"] = r.def.Interval
+	}
+	if r.enabled && !r.nextRun.IsZero() {
+		info["nextRun"] = r.nextRun.Format(time.RFC3339)
+	}
+	if !r.lastStart.IsZero() {
+		info["lastRun"] = r.lastStart.Format(time.RFC3339)
+		info["lastCode"] = r.lastCode
+		info["lastDuration"] = r.lastDuration
+		if len(r.lastError) > 0 {
+			info["lastError"] = r.lastError
+		}
+	}
+	return info
+}
+
+func (r *triggerRunner) overlap() string {
+	if len(r.def.Overlap) == 0 {
+		return TriggerOverlapSkip
+	}
+	return r.def.Overlap
+}
+
+// 启动定时goroutine，调用时需要持有triggerLock
+func (r *triggerRunner) start() {
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	go r.loop(r.stop)
+}
+
+// 停止定时goroutine，已经在运行的schedule不受影响，调用时需要持有triggerLock
+func (r *triggerRunner) halt() {
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	r.nextRun = time.Time{}
+}
+
+func (r *triggerRunner) loop(stop chan struct{}) {
+	for {
+		next := r.nextTime(time.Now())
+		if next.IsZero() {
+			logger.LogS().Errorln("trigger：", r.def.Name, "无法计算下次运行时间，停止")
+			return
+		}
+
+		//halt在triggerLock中关闭stop，已经停止时不再更新nextRun
+		triggerLock.Lock()
+		select {
+		case <-stop:
+			triggerLock.Unlock()
+			return
+		default:
+		}
+		r.nextRun = next
+		triggerLock.Unlock()
+
+		delay := time.Until(next)
+		if r.def.Jitter > 0 {
+			delay += time.Duration(rand.Intn(r.def.Jitter)) * time.Millisecond
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			r.fire()
+		}
+	}
+}
+
+// 根据overlap策略决定是否运行schedule
+func (r *triggerRunner) fire() bool {
+	triggerLock.Lock()
+	defer triggerLock.Unlock()
+
+	if r.running > 0 {
+		switch r.overlap() {
+		case TriggerOverlapAllow:
+		case TriggerOverlapQueue:
+			//最多排队一次
+			if r.pending == 0 {
+				r.pending++
+				return true
+			}
+			r.skipped++
+			logger.LogS().Warnln("trigger：", r.def.Name, "已有排队的运行，跳过")
+			return false
+		default:
+			r.skipped++
+			logger.LogS().Warnln("trigger：", r.def.Name, "上次运行尚未结束，跳过")
+			return false
+		}
+	}
+
+	r.running++
+	go r.run()
+	return true
+}
+
+func (r *triggerRunner) run() {
+	start := time.Now()
+	stack := &hub.Stack{
+		BaseString: " trigger: " + r.def.Name + ". ",
+		StartTime:  start,
+		Context:    context.Background(),
+	}
+	base := map[string]interface{}{"root": r.def.Schedule, "type": "trigger", "trigger": r.def.Name, "start": strconv.FormatInt(start.Unix(), 10)}
+	stack.Heap = hub.NewHeap(map[string]interface{}{hub.HeapOriginName: make(map[string]interface{}), hub.HeapBaseName: base})
+
+	var result interface{}
+	code := http.StatusInternalServerError
+	//schedule中发生panic时记录为失败，不会导致整个服务退出，也不会影响trigger之后的运行
+	defer func() {
+		if p := recover(); p != nil {
+			str := "trigger执行失败：" + r.def.Name + " panic:" + fmt.Sprint(p)
+			logger.LogS().Errorln(stack.BaseString, str, "\n", string(debug.Stack()))
+			result, code = util.CreateTmsError(hub.TmsErrorPanicId, str, nil), http.StatusInternalServerError
+		}
+		r.finish(start, result, code)
+	}()
+
+	logger.LogS().Infoln(stack.BaseString, "触发Schedule：", r.def.Schedule)
+	result, code = runSchedule(stack, r.def.Schedule, r.def.Private)
+	logger.LogS().Infoln(stack.BaseString, "Schedule结束 code:", code)
+}
+
+// 记录运行结果，有排队的运行时继续执行
+func (r *triggerRunner) finish(start time.Time, result interface{}, code int) {
+	triggerLock.Lock()
+	defer triggerLock.Unlock()
+
+	r.running--
+	r.runs++
+	r.lastStart = start
+	r.lastDuration = time.Since(start).Seconds()
+	r.lastCode = code
+	r.lastError = ""
+	if code != http.StatusOK {
+		r.failures++
+		if tmsErr, ok := result.(hub.TmsError); ok {
+			r.lastError = tmsErr.ErrorMsg
+		}
+	}
+
+	if r.pending > 0 && r.running == 0 {
+		r.pending--
+		r.running++
+		go r.run()
+	}
+}
+
+// 停止所有trigger，按照defs重新创建并启动
+func StartTriggers(defs map[string]*hub.TriggerDef) []interface{} {
+	triggerLock.Lock()
+	for _, r := range triggerMap {
+		r.halt()
+	}
+	triggerMap = make(map[string]*triggerRunner, len(defs))
+
+	for name, def := range defs {
+		r, err := newTriggerRunner(def)
+		if err != nil {
+			logger.LogS().Errorln("加载trigger：", name, "失败：", err)
+			continue
+		}
+		triggerMap[name] = r
+		if r.enabled {
+			r.start()
+		}
+	}
+	logger.LogS().Infoln("启动trigger数量：", len(triggerMap))
+	triggerLock.Unlock()
+
+	return ListTriggers()
+}
+
+// trigger的运行统计，用于prometheus采集
+type TriggerStats struct {
+	Name         string
+	Schedule     string
+	Enabled      bool
+	Running      int
+	Runs         int64
+	Failures     int64
+	Skipped      int64
+	LastDuration float64
+}
+
+func GetTriggerStats() []TriggerStats {
+	triggerLock.Lock()
+	defer triggerLock.Unlock()
+
+	result := make([]TriggerStats, 0, len(triggerMap))
+	for name, r := range triggerMap {
+		result = append(result, TriggerStats{
+			Name:         name,
+			Schedule:     r.def.Schedule,
+			Enabled:      r.enabled,
+			Running:      r.running,
+			Runs:         r.runs,
+			Failures:     r.failures,
+			Skipped:      r.skipped,
+			LastDuration: r.lastDuration,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func ListTriggers() []interface{} {
+	triggerLock.Lock()
+	defer triggerLock.Unlock()
+
+	names := make([]string, 0, len(triggerMap))
+	for name := range triggerMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]interface{}, len(names))
+	for i, name := range names {
+		result[i] = triggerMap[name].info()
+	}
+	return result
+}
+
+func GetTrigger(name string) (map[string]interface{}, bool) {
+	triggerLock.Lock()
+	defer triggerLock.Unlock()
+
+	r, ok := triggerMap[name]
+	if !ok {
+		return nil, false
+	}
+	return r.info(), true
+}
+
+// 启用或停用trigger，重新加载后恢复为配置中的状态
+func EnableTrigger(name string, enable bool) (map[string]interface{}, bool) {
+	triggerLock.Lock()
+	defer triggerLock.Unlock()
+
+	r, ok := triggerMap[name]
+	if !ok {
+		return nil, false
+	}
+	r.enabled = enable
+	if enable {
+		r.start()
+	} else {
+		r.halt()
+	}
+	logger.LogS().Infoln("trigger：", name, " enabled:", enable)
+	return r.info(), true
+}
+
+// 立即触发一次，同样遵循overlap策略
+func RunTrigger(name string) (map[string]interface{}, bool) {
+	triggerLock.Lock()
+	r, ok := triggerMap[name]
+	triggerLock.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	r.fire()
+	return GetTrigger(name)
+}
+
+func ReloadTriggers() []interface{} {
+	return StartTriggers(util.LoadTriggerConf())
+}
+
+func getTriggerName(stack *hub.Stack, params map[string]string) (string, interface{}, int) {
+	name, OK := params["name"]
+	if !OK {
+		str := "缺少trigger名称"
+		logger.LogS().Errorln(stack.BaseString, str)
+		return "", util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusBadRequest
+	}
+	return name, nil, http.StatusOK
+}
+
+func triggerNotFound(stack *hub.Stack, name string) (interface{}, int) {
+	str := "trigger不存在：" + name
+	logger.LogS().Errorln(stack.BaseString, str)
+	return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusNotFound
+}
+
+func triggerStart(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	return StartTriggers(util.GetTriggerDefs()), http.StatusOK
+}
+
+func triggerReload(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	return ReloadTriggers(), http.StatusOK
+}
+
+func triggerList(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	return ListTriggers(), http.StatusOK
+}
+
+func triggerEnable(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	name, result, code := getTriggerName(stack, params)
+	if code != http.StatusOK {
+		return result, code
+	}
+
+	info, ok := EnableTrigger(name, true)
+	if !ok {
+		return triggerNotFound(stack, name)
+	}
+	return info, http.StatusOK
+}
+
+func triggerDisable(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	name, result, code := getTriggerName(stack, params)
+	if code != http.StatusOK {
+		return result, code
+	}
+
+	info, ok := EnableTrigger(name, false)
+	if !ok {
+		return triggerNotFound(stack, name)
+	}
+	return info, http.StatusOK
+}
+
+func triggerRun(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	name, result, code := getTriggerName(stack, params)
+	if code != http.StatusOK {
+		return result, code
+	}
+
+	info, ok := RunTrigger(name)
+	if !ok {
+		return triggerNotFound(stack, name)
+	}
+	return info, http.StatusOK
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func waitTriggerIdle(t *testing.T, name string) map[string]interface{} {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		triggerLock.Lock()
+		r := triggerMap[name]
+		idle := r.running == 0 && r.runs > 0
+		info := r.info()
+		triggerLock.Unlock()
+		if idle {
+			return info
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("trigger %s still running", name)
+	return nil
+}
+
+func TestTriggerRunRecoversPanic(t *testing.T) {
+	//没有control的switch会panic
+	addTestSchedule(t, &hub.ScheduleDef{Name: "test-panic", Steps: &[]hub.ScheduleApiDef{{Type: "switch"}}})
+	r, err := newTriggerRunner(&hub.TriggerDef{Name: "test-panic", Schedule: "test-panic", Interval: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	triggerLock.Lock()
+	triggerMap["test-panic"] = r
+	triggerLock.Unlock()
+	t.Cleanup(func() {
+		triggerLock.Lock()
+		delete(triggerMap, "test-panic")
+		triggerLock.Unlock()
+	})
+
+	if !r.fire() {
+		t.Fatal("trigger not fired")
+	}
+	info := waitTriggerIdle(t, "test-panic")
+	if info["failures"] != int64(1) || info["lastCode"] != http.StatusInternalServerError {
+		t.Fatalf("trigger = %v", info)
+	}
+
+	//panic后trigger可以继续运行
+	if !r.fire() {
+		t.Fatal("trigger wedged after panic")
+	}
+	for i := 0; i < 100 && waitTriggerIdle(t, "test-panic")["runs"] != int64(2); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info := waitTriggerIdle(t, "test-panic"); info["runs"] != int64(2) {
+		t.Fatalf("trigger = %v", info)
+	}
+}
+
+func TestTriggerHaltClearsNextRun(t *testing.T) {
+	r, err := newTriggerRunner(&hub.TriggerDef{Name: "test-halt", Schedule: "test-halt", Interval: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	triggerLock.Lock()
+	r.start()
+	stop := r.stop
+	r.halt()
+	triggerLock.Unlock()
+
+	//已经停止的goroutine不再更新nextRun
+	r.loop(stop)
+	triggerLock.Lock()
+	defer triggerLock.Unlock()
+	if !r.nextRun.IsZero() {
+		t.Fatalf("nextRun = %v after halt", r.nextRun)
+	}
+}
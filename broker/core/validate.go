@@ -0,0 +1,509 @@
+package core
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+	"github.com/jasony62/tms-go-apihub/util"
+)
+
+var confRefLock sync.Mutex
+
+// command参数中引用的配置，参数为literal时检查引用是否存在
+var confRefMap = map[string]map[string]hub.ConfRefDef{
+	"flowApi":        {"name": {Type: hub.JSON_TYPE_FLOW}},
+	"scheduleApi":    {"name": {Type: hub.JSON_TYPE_SCHEDULE}},
+	"triggerEnable":  {"name": {Type: hub.JSON_TYPE_TRIGGER}},
+	"triggerDisable": {"name": {Type: hub.JSON_TYPE_TRIGGER}},
+	"triggerRun":     {"name": {Type: hub.JSON_TYPE_TRIGGER}},
+}
+
+var scheduleTaskTypes = map[string]bool{"api": true, "loop": true, "foreach": true, "while": true, "until": true, "switch": true}
+
+// 注册command参数引用的配置，以_开头的名称不对应参数，只用于检查command固定依赖的配置
+func RegisterConfRefs(list map[string]map[string]hub.ConfRefDef) {
+	confRefLock.Lock()
+	defer confRefLock.Unlock()
+
+	for command, refs := range list {
+		if confRefMap[command] == nil {
+			confRefMap[command] = make(map[string]hub.ConfRefDef)
+		}
+		for name, ref := range refs {
+			confRefMap[command][name] = ref
+		}
+	}
+}
+
+func getConfRefs(command string) map[string]hub.ConfRefDef {
+	confRefLock.Lock()
+	defer confRefLock.Unlock()
+	return confRefMap[command]
+}
+
+func isApiRegistered(command string) bool {
+	mapLock.Lock()
+	defer mapLock.Unlock()
+	return apiMap[command] != nil
+}
+
+func confTypeName(jsonType int) string {
+	switch jsonType {
+	case hub.JSON_TYPE_PRIVATE:
+		return "private"
+	case hub.JSON_TYPE_API:
+		return "httpapi"
+	case hub.JSON_TYPE_FLOW:
+		return "flow"
+	case hub.JSON_TYPE_SCHEDULE:
+		return "schedule"
+	case hub.JSON_TYPE_TRIGGER:
+		return "trigger"
+	case hub.JSON_TYPE_CLIENT:
+		return "client"
+	default:
+		return strconv.Itoa(jsonType)
+	}
+}
+
+func confExists(jsonType int, name string) bool {
+	var ok bool
+	switch jsonType {
+	case hub.JSON_TYPE_PRIVATE:
+		_, ok = util.FindPrivateDef(name)
+	case hub.JSON_TYPE_API:
+		_, ok = util.FindHttpApiDef(name)
+	case hub.JSON_TYPE_FLOW:
+		_, ok = util.FindFlowDef(name)
+	case hub.JSON_TYPE_SCHEDULE:
+		_, ok = util.FindScheduleDef(name)
+	case hub.JSON_TYPE_TRIGGER:
+		_, ok = util.GetTriggerDefs()[name]
+	case hub.JSON_TYPE_CLIENT:
+		_, ok = util.FindHttpClientDef(name)
+	default:
+		ok = true
+	}
+	return ok
+}
+
+type confChecker struct {
+	problems []hub.ConfProblem
+	file     string
+	name     string
+}
+
+func (c *confChecker) reset(jsonType int, name string) {
+	c.file = util.GetConfFile(jsonType, name)
+	c.name = name
+}
+
+func (c *confChecker) add(location string, msg string) {
+	c.problems = append(c.problems, hub.ConfProblem{File: c.file, Name: c.name, Location: location, Message: msg})
+}
+
+func (c *confChecker) checkTemplate(location string, content string) {
+	if err := util.CheckTemplate(content); err != nil {
+		c.add(location, "模板语法错误："+err.Error())
+	}
+}
+
+func (c *confChecker) checkRef(location string, ref hub.ConfRefDef, name string) {
+	if len(name) == 0 || name == "none" {
+		return
+	}
+	if !confExists(ref.Type, name) {
+		c.add(location, confTypeName(ref.Type)+"不存在："+name)
+	}
+}
+
+func (c *confChecker) checkPrivate(location string, name string) {
+	c.checkRef(location, hub.ConfRefDef{Type: hub.JSON_TYPE_PRIVATE}, name)
+}
+
+func (c *confChecker) checkValue(location string, value *hub.BaseValueDef) {
+	switch value.From {
+	case "literal", "header", "query", "env", "private":
+	case hub.HeapOriginName:
+		c.checkTemplate(location, "{{.origin."+value.Content+"}}")
+	case "heap":
+		c.checkTemplate(location, "{{."+value.Content+"}}")
+	case "template":
+		c.checkTemplate(location, value.Content)
+	case "json", "jsonRaw":
+		if value.Json == nil {
+			c.add(location, "缺少json定义")
+		} else if err := util.CheckJsonTemplate(value.Json); err != nil {
+			c.add(location, "模板语法错误："+err.Error())
+		}
+	case "func":
+		if !util.HasFunc(value.Content) {
+			c.add(location, "function不存在："+value.Content)
+		}
+	default:
+		c.add(location, "不支持的from："+value.From)
+	}
+}
+
+func (c *confChecker) checkRetry(location string, retry *hub.RetryDef) {
+	if retry == nil {
+		return
+	}
+	if retry.MaxAttempts <= 0 {
+		c.add(location+".maxAttempts", "缺少maxAttempts或小于1")
+	}
+	switch retry.Backoff {
+	case "", "fixed", "exponential":
+	default:
+		c.add(location+".backoff", "不支持的backoff："+retry.Backoff)
+	}
+	if retry.Interval < 0 {
+		c.add(location+".interval", "不能小于0")
+	}
+	if retry.MaxInterval < 0 {
+		c.add(location+".maxInterval", "不能小于0")
+	}
+	for _, code := range retry.Codes {
+		if code < 100 || code > 599 {
+			c.add(location+".codes", "无效的状态码："+strconv.Itoa(code))
+		}
+	}
+}
+
+func (c *confChecker) checkApi(location string, api *hub.ApiDef) {
+	if len(api.Parallel) > 0 {
+		switch api.JoinMode {
+		case "", "waitAll", "failFast", "firstSuccess", joinModeAllSettled:
+		default:
+			c.add(location+".joinMode", "不支持的joinMode："+api.JoinMode)
+		}
+		if len(api.When) > 0 {
+			c.checkTemplate(location+".when", api.When)
+		}
+		c.checkApis(location+".parallel", api.Parallel)
+		c.checkApis(location+".onError", api.OnError)
+		c.checkApis(location+".compensate", api.Compensate)
+		return
+	}
+	if !isApiRegistered(api.Command) {
+		c.add(location, "command不存在："+api.Command)
+	}
+	c.checkPrivate(location+".private", api.Private)
+	if len(api.When) > 0 {
+		c.checkTemplate(location+".when", api.When)
+	}
+	c.checkRetry(location+".retry", api.Retry)
+
+	refs := getConfRefs(api.Command)
+	found := make(map[string]bool)
+	if api.Args != nil {
+		for _, arg := range *api.Args {
+			argLocation := location + ".args." + arg.Name
+			found[arg.Name] = true
+			c.checkValue(argLocation, &arg.Value)
+			if ref, ok := refs[arg.Name]; ok && arg.Value.From == "literal" {
+				c.checkRef(argLocation, ref, arg.Value.Content)
+			}
+		}
+	}
+	for _, name := range sortedKeys(refs) {
+		if ref := refs[name]; !found[name] && len(ref.Default) > 0 {
+			c.checkRef(location+".args."+name, ref, ref.Default)
+		}
+	}
+
+	if api.OriginParameters != nil {
+		for _, param := range *api.OriginParameters {
+			c.checkValue(location+".origin."+param.Name, &param.Value)
+		}
+	}
+	c.checkApis(location+".onError", api.OnError)
+	c.checkApis(location+".compensate", api.Compensate)
+}
+
+func (c *confChecker) checkApis(location string, apis []hub.ApiDef) {
+	for i := range apis {
+		c.checkApi(location+"["+strconv.Itoa(i)+"]", &apis[i])
+	}
+}
+
+func (c *confChecker) checkFlow(flow *hub.FlowDef) {
+	c.checkPrivate("private", flow.Private)
+	switch flow.Scope {
+	case "", hub.FlowScopeShared, hub.FlowScopeIsolated:
+	default:
+		c.add("scope", "不支持的scope："+flow.Scope)
+	}
+	for i := range flow.Inputs {
+		input := &flow.Inputs[i]
+		if _, err := convertFlowInput(input, nil); err != nil {
+			c.add("inputs."+input.Name, err.Error())
+		}
+	}
+	for _, output := range flow.Outputs {
+		if output.Value != nil {
+			c.checkValue("outputs."+output.Name, output.Value)
+		}
+	}
+	c.checkApis("steps", flow.Steps)
+	c.checkApis("onError", flow.OnError)
+	c.checkApis("finally", flow.Finally)
+}
+
+func (c *confChecker) checkControl(location string, control *hub.ScheduleControlDef) {
+	if len(control.Key.From) > 0 {
+		c.checkValue(location+".key", &control.Key)
+	}
+	if control.Cases != nil {
+		for i := range *control.Cases {
+			caseDef := &(*control.Cases)[i]
+			caseLocation := location + ".cases[" + strconv.Itoa(i) + "]"
+			if len(caseDef.Regex) > 0 {
+				if _, err := regexp.Compile(caseDef.Regex); err != nil {
+					c.add(caseLocation+".regex", "正则表达式错误："+err.Error())
+				}
+			}
+			c.checkTasks(caseLocation+".steps", caseDef.Steps)
+		}
+	}
+	c.checkTasks(location+".steps", control.Steps)
+	c.checkDagJoinMode(location+".joinMode", control.Steps, control.JoinMode)
+}
+
+// 按照dependsOn执行时只支持waitAll和failFast
+func (c *confChecker) checkDagJoinMode(location string, tasks *[]hub.ScheduleApiDef, joinMode string) {
+	if tasks == nil || !util.IsScheduleDag(*tasks) {
+		return
+	}
+	switch joinMode {
+	case "", "waitAll", "failFast":
+	default:
+		c.add(location, "dependsOn不支持的joinMode："+joinMode)
+	}
+}
+
+func (c *confChecker) checkTasks(location string, tasks *[]hub.ScheduleApiDef) {
+	if tasks == nil {
+		return
+	}
+
+	for i := range *tasks {
+		task := &(*tasks)[i]
+		taskLocation := location + "[" + strconv.Itoa(i) + "]"
+		if !scheduleTaskTypes[task.Type] {
+			c.add(taskLocation+".type", "不支持的type："+task.Type)
+			continue
+		}
+		c.checkPrivate(taskLocation+".private", task.Private)
+		c.checkRetry(taskLocation+".retry", task.Retry)
+		c.checkTasks(taskLocation+".compensate", task.Compensate)
+
+		if task.Type == "api" {
+			if task.Api == nil {
+				c.add(taskLocation, "缺少api定义")
+			} else {
+				c.checkApi(taskLocation+".api", task.Api)
+			}
+		} else {
+			if task.Control == nil {
+				c.add(taskLocation, "缺少control定义")
+			} else {
+				if (task.Type == "while" || task.Type == "until") && task.Control.MaxIteration <= 0 {
+					c.add(taskLocation+".control.maxIteration", task.Type+"缺少maxIteration")
+				}
+				c.checkControl(taskLocation+".control", task.Control)
+			}
+		}
+	}
+}
+
+func (c *confChecker) checkSchedule(schedule *hub.ScheduleDef) {
+	if schedule.Steps == nil {
+		c.add("steps", "缺少steps")
+	}
+	c.checkTasks("steps", schedule.Steps)
+	c.checkDagJoinMode("joinMode", schedule.Steps, schedule.JoinMode)
+	c.checkTasks("onError", schedule.OnError)
+	c.checkTasks("finally", schedule.Finally)
+}
+
+func (c *confChecker) checkHttpApi(api *hub.HttpApiDef) {
+	c.checkPrivate("private", api.PrivateName)
+	if len(api.Url) == 0 && api.DynamicUrl == nil {
+		c.add("url", "缺少url或dynamicUrl")
+	}
+	if api.DynamicUrl != nil {
+		c.checkValue("dynamicUrl", api.DynamicUrl)
+	}
+	if api.Args != nil {
+		bodyCount := 0
+		for _, arg := range *api.Args {
+			c.checkValue("args."+arg.Name, &arg.Value)
+			if arg.File != nil {
+				c.checkHttpFile("args."+arg.Name, api, &arg)
+			}
+			if arg.In == "body" {
+				bodyCount++
+			}
+		}
+		if api.RequestContentType == "binary" && bodyCount > 1 {
+			c.add("args", "binary只能有1个body参数")
+		}
+	}
+	c.checkPathParams(api)
+	if api.Cache != nil && api.Cache.Expire != nil {
+		c.checkValue("cache.expire", api.Cache.Expire)
+	}
+	c.checkRef("client", hub.ConfRefDef{Type: hub.JSON_TYPE_CLIENT}, api.Client)
+}
+
+func (c *confChecker) checkHttpFile(location string, api *hub.HttpApiDef, arg *hub.HttpApiDefParam) {
+	switch arg.File.Source {
+	case "base64", "file", "upload":
+	default:
+		c.add(location+".file.source", "不支持的file source："+arg.File.Source)
+	}
+	if arg.In != "body" {
+		c.add(location+".file", "file只能用于body参数")
+	}
+	if api.RequestContentType != "multipart" && api.RequestContentType != "binary" {
+		c.add(location+".file", "file只能用于multipart或binary的requestContentType")
+	}
+}
+
+var urlPathParamRegexp = regexp.MustCompile(`\{([^{}/?]+)\}`)
+
+// url中的{name}和path参数需要一一对应，dynamicUrl在运行时才能确定，不检查
+func (c *confChecker) checkPathParams(api *hub.HttpApiDef) {
+	if len(api.Url) == 0 {
+		return
+	}
+	params := make(map[string]bool)
+	if api.Args != nil {
+		for _, arg := range *api.Args {
+			if arg.In == "path" {
+				params[arg.Name] = true
+			}
+		}
+	}
+
+	path := api.Url
+	if index := strings.Index(path, "?"); index >= 0 {
+		path = path[:index]
+	}
+	placeholders := make(map[string]bool)
+	for _, match := range urlPathParamRegexp.FindAllStringSubmatch(path, -1) {
+		placeholders[match[1]] = true
+		if !params[match[1]] {
+			c.add("url", "{"+match[1]+"}没有对应的path参数")
+		}
+	}
+	for _, name := range sortedKeys(params) {
+		if !placeholders[name] {
+			c.add("args."+name, "url中没有{"+name+"}")
+		}
+	}
+}
+
+func (c *confChecker) checkHttpClient(client *hub.HttpClientDef) {
+	values := map[string]int{
+		"readTimeout":         client.ReadTimeout,
+		"writeTimeout":        client.WriteTimeout,
+		"dialTimeout":         client.DialTimeout,
+		"maxConnsPerHost":     client.MaxConnsPerHost,
+		"maxIdleConnDuration": client.MaxIdleConnDuration,
+		"maxConnWaitTimeout":  client.MaxConnWaitTimeout,
+		"dnsCacheDuration":    client.DnsCacheDuration,
+	}
+	for _, name := range sortedKeys(values) {
+		if values[name] < 0 {
+			c.add(name, "不能小于0")
+		}
+	}
+}
+
+func (c *confChecker) checkTrigger(trigger *hub.TriggerDef) {
+	c.checkRef("schedule", hub.ConfRefDef{Type: hub.JSON_TYPE_SCHEDULE}, trigger.Schedule)
+	c.checkPrivate("private", trigger.Private)
+	if _, err := newTriggerRunner(trigger); err != nil {
+		c.add("", err.Error())
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 检查所有已加载的配置，返回全部问题
+func ValidateConf() []hub.ConfProblem {
+	c := &confChecker{}
+	c.problems = append(c.problems, util.GetLoadProblems()...)
+
+	//重新加载配置时会替换map，检查使用同一份配置
+	conf := util.GetConfSnapshot()
+	for _, name := range sortedKeys(conf.ApiMap) {
+		c.reset(hub.JSON_TYPE_API, name)
+		c.checkHttpApi(conf.ApiMap[name])
+	}
+
+	for _, name := range sortedKeys(conf.ClientMap) {
+		c.reset(hub.JSON_TYPE_CLIENT, name)
+		c.checkHttpClient(conf.ClientMap[name])
+	}
+
+	for _, name := range sortedKeys(conf.FlowMap) {
+		c.reset(hub.JSON_TYPE_FLOW, name)
+		c.checkFlow(conf.FlowMap[name])
+	}
+
+	for _, name := range sortedKeys(conf.ScheduleMap) {
+		c.reset(hub.JSON_TYPE_SCHEDULE, name)
+		c.checkSchedule(conf.ScheduleMap[name])
+	}
+
+	triggers := util.GetTriggerDefs()
+	for _, name := range sortedKeys(triggers) {
+		trigger := triggers[name]
+		if file := util.GetConfFile(hub.JSON_TYPE_TRIGGER, name); len(file) > 0 {
+			c.reset(hub.JSON_TYPE_TRIGGER, name)
+		} else {
+			//定义在schedule中的trigger
+			c.reset(hub.JSON_TYPE_SCHEDULE, trigger.Schedule)
+			c.name = name
+		}
+		c.checkTrigger(trigger)
+	}
+	return c.problems
+}
+
+func confCheck(stack *hub.Stack, params map[string]string) (interface{}, int) {
+	problems := ValidateConf()
+	for _, problem := range problems {
+		logger.LogS().Errorln(stack.BaseString, "配置错误 file:", problem.File, " name:", problem.Name, " location:", problem.Location, " ", problem.Message)
+	}
+
+	result := map[string]interface{}{"count": len(problems), "problems": problems}
+	if len(problems) == 0 {
+		logger.LogS().Infoln(stack.BaseString, "配置检查通过")
+		return result, http.StatusOK
+	}
+
+	if params["strict"] == "true" {
+		str := "配置检查发现" + strconv.Itoa(len(problems)) + "个问题"
+		logger.LogS().Errorln(stack.BaseString, str)
+		return util.CreateTmsError(hub.TmsErrorCoreId, str, nil), http.StatusInternalServerError
+	}
+	logger.LogS().Warnln(stack.BaseString, "配置检查发现", len(problems), "个问题")
+	return result, http.StatusOK
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func checkTestLocations(t *testing.T, c *confChecker, want ...string) {
+	t.Helper()
+	if len(c.problems) != len(want) {
+		t.Fatalf("problems = %v, want %v", c.problems, want)
+	}
+	for i, problem := range c.problems {
+		if problem.Location != want[i] {
+			t.Errorf("problems[%d] = %v, want location %s", i, problem, want[i])
+		}
+	}
+}
+
+func TestCheckRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry *hub.RetryDef
+		want  []string
+	}{
+		{"valid", &hub.RetryDef{MaxAttempts: 3, Backoff: "exponential", Interval: 100, Codes: []int{502, 503}}, nil},
+		{"missing maxAttempts", &hub.RetryDef{}, []string{"retry.maxAttempts"}},
+		{"invalid backoff", &hub.RetryDef{MaxAttempts: 2, Backoff: "linear"}, []string{"retry.backoff"}},
+		{"negative interval", &hub.RetryDef{MaxAttempts: 2, Interval: -1, MaxInterval: -1}, []string{"retry.interval", "retry.maxInterval"}},
+		{"invalid code", &hub.RetryDef{MaxAttempts: 2, Codes: []int{500, 1000}}, []string{"retry.codes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &confChecker{}
+			c.checkRetry("retry", tt.retry)
+			checkTestLocations(t, c, tt.want...)
+		})
+	}
+}
+
+func TestCheckTasksRetryAndMaxIteration(t *testing.T) {
+	control := &hub.ScheduleControlDef{Name: "loop", Key: hub.BaseValueDef{From: "literal", Content: "true"}}
+	c := &confChecker{}
+	c.checkTasks("steps", &[]hub.ScheduleApiDef{
+		{Type: "while", Control: control},
+		{Type: "until", Control: &hub.ScheduleControlDef{Name: "ok", MaxIteration: 3}},
+		{Type: "loop", Control: &hub.ScheduleControlDef{Name: "loop"}, Retry: &hub.RetryDef{Backoff: "fixed"}},
+	})
+	checkTestLocations(t, c, "steps[0].control.maxIteration", "steps[2].retry.maxAttempts")
+}
@@ -6,6 +6,24 @@ type ApiDef struct {
 	Private          string          `json:"private"`
 	Description      string          `json:"description"`
 	ResultKey        string          `json:"resultKey"`
+	When             string          `json:"when,omitempty"`
+	Timeout          int             `json:"timeout,omitempty"`
 	Args             *[]BaseParamDef `json:"args"`
 	OriginParameters *[]BaseParamDef `json:"origin"`
+	Retry            *RetryDef       `json:"retry,omitempty"`
+	OnError          []ApiDef        `json:"onError,omitempty"`
+	Compensate       []ApiDef        `json:"compensate,omitempty"`
+	/*只用于parallel分组*/
+	Parallel      []ApiDef `json:"parallel,omitempty"`
+	ConcurrentNum int      `json:"concurrentNum,omitempty"`
+	JoinMode      string   `json:"joinMode,omitempty"`
+}
+
+type RetryDef struct {
+	MaxAttempts int    `json:"maxAttempts"`
+	Backoff     string `json:"backoff"`
+	Interval    int    `json:"interval"`
+	MaxInterval int    `json:"maxInterval,omitempty"`
+	Jitter      bool   `json:"jitter,omitempty"`
+	Codes       []int  `json:"codes,omitempty"`
 }
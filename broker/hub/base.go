@@ -22,6 +22,11 @@ const HeapBaseName = "base"
 const HeapRootName = "root"
 const HeapStatsName = "stats"
 const HeapResultName = "result"
+const HeapRetryName = "retry"
+const HeapErrorName = "error"
+const HeapForeachName = "foreach"
+const HeapJobName = "job"
+const HeapCompensateName = "compensate"
 
 const Right_Access = "access"
 const Right_Deny = "deny"
@@ -39,4 +44,6 @@ const (
 	JSON_TYPE_API_RIGHT
 	JSON_TYPE_FLOW_RIGHT
 	JSON_TYPE_SCHEDULE_RIGHT
+	JSON_TYPE_TRIGGER
+	JSON_TYPE_CLIENT
 )
@@ -0,0 +1,14 @@
+package hub
+
+// 上游HTTP连接池的配置，同一个client的请求按照上游地址共享连接，时间单位为毫秒
+type HttpClientDef struct {
+	Name                string `json:"name"`
+	Description         string `json:"description"`
+	ReadTimeout         int    `json:"readTimeout,omitempty"`
+	WriteTimeout        int    `json:"writeTimeout,omitempty"`
+	DialTimeout         int    `json:"dialTimeout,omitempty"`
+	MaxConnsPerHost     int    `json:"maxConnsPerHost,omitempty"`
+	MaxIdleConnDuration int    `json:"maxIdleConnDuration,omitempty"`
+	MaxConnWaitTimeout  int    `json:"maxConnWaitTimeout,omitempty"`
+	DnsCacheDuration    int    `json:"dnsCacheDuration,omitempty"`
+}
@@ -0,0 +1,15 @@
+package hub
+
+// API参数引用的配置，Type为JSON_TYPE_*，Default为参数未配置时使用的名称
+type ConfRefDef struct {
+	Type    int
+	Default string
+}
+
+// 配置检查发现的问题
+type ConfProblem struct {
+	File     string `json:"file"`
+	Name     string `json:"name"`
+	Location string `json:"location,omitempty"`
+	Message  string `json:"message"`
+}
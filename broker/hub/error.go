@@ -11,3 +11,32 @@ type TmsError struct {
 const TmsErrorCoreId = 10000
 const TmsErrorApisId = 20000
 const TmsErrorUtilId = 30000
+
+const (
+	TmsErrorPanicId       = TmsErrorCoreId + 1 // 执行step时发生panic
+	TmsErrorBodyId        = TmsErrorApisId + 1 // 请求的body无效
+	TmsErrorPathId        = TmsErrorApisId + 2 // path参数为空
+	TmsErrorUrlId         = TmsErrorApisId + 3 // url无效
+	TmsErrorUnknownFromId = TmsErrorUtilId + 1 // 不支持的from
+	TmsErrorFuncId        = TmsErrorUtilId + 2 // function不存在
+	TmsErrorLoadId        = TmsErrorUtilId + 3 // 加载定义文件失败
+	TmsErrorNoRequestId   = TmsErrorUtilId + 4 // 没有收到的请求
+)
+
+// 可以作为error返回的TmsError，Code为对应的http状态码。
+// 经过多层返回后仍然保留最初的Id和位置，最终作为NOK的结果返回。
+type StatusError struct {
+	TmsError
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	if e.TmsError.Error != nil {
+		return e.ErrorMsg + "：" + e.TmsError.Error.Error()
+	}
+	return e.ErrorMsg
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.TmsError.Error
+}
@@ -1,8 +1,30 @@
 package hub
 
+const FlowScopeShared = "shared"
+const FlowScopeIsolated = "isolated"
+
+type FlowInputDef struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Type        string      `json:"type,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+}
+
+type FlowOutputDef struct {
+	Name  string        `json:"name"`
+	Value *BaseValueDef `json:"value,omitempty"`
+}
+
 type FlowDef struct {
-	Name        string   `json:"name"`
-	Private     string   `json:"private"`
-	Description string   `json:"description"`
-	Steps       []ApiDef `json:"steps"`
+	Name        string          `json:"name"`
+	Private     string          `json:"private"`
+	Description string          `json:"description"`
+	Timeout     int             `json:"timeout,omitempty"`
+	Scope       string          `json:"scope,omitempty"`
+	Inputs      []FlowInputDef  `json:"inputs,omitempty"`
+	Outputs     []FlowOutputDef `json:"outputs,omitempty"`
+	Steps       []ApiDef        `json:"steps"`
+	OnError     []ApiDef        `json:"onError,omitempty"`
+	Finally     []ApiDef        `json:"finally,omitempty"`
 }
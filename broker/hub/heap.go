@@ -0,0 +1,112 @@
+package hub
+
+import "sync"
+
+// 1次请求中保存执行结果的heap，可以在多个goroutine中同时读写。
+// 保存在heap中的值只读，需要修改map中的值时通过SetHeapMapValue复制一份新的map（copy-on-write），
+// 因此Snapshot和其他scope中共享的值不会被修改。
+type Heap struct {
+	lock   sync.RWMutex
+	parent *Heap
+	values map[string]interface{}
+}
+
+func NewHeap(values map[string]interface{}) *Heap {
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	return &Heap{values: values}
+}
+
+// 当前scope中没有时从上层scope中查找
+func (h *Heap) Get(key string) (interface{}, bool) {
+	h.lock.RLock()
+	value, ok := h.values[key]
+	h.lock.RUnlock()
+	if !ok && h.parent != nil {
+		return h.parent.Get(key)
+	}
+	return value, ok
+}
+
+// 只写入当前scope
+func (h *Heap) Set(key string, value interface{}) {
+	h.lock.Lock()
+	h.values[key] = value
+	h.lock.Unlock()
+}
+
+// 只删除当前scope中的值，上层scope中的同名值仍然可见
+func (h *Heap) Delete(key string) {
+	h.lock.Lock()
+	delete(h.values, key)
+	h.lock.Unlock()
+}
+
+// 创建下层scope，读取时可以看到当前scope的值，写入的值只在下层scope中可见
+func (h *Heap) Scope() *Heap {
+	return &Heap{parent: h, values: make(map[string]interface{})}
+}
+
+// 返回所有可见值的副本，用于执行模板等只读操作
+func (h *Heap) Snapshot() map[string]interface{} {
+	var result map[string]interface{}
+	if h.parent != nil {
+		result = h.parent.Snapshot()
+	} else {
+		result = make(map[string]interface{})
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for k, v := range h.values {
+		result[k] = v
+	}
+	return result
+}
+
+// 在锁内根据当前可见的值计算新值并写入当前scope
+func (h *Heap) update(key string, fn func(old interface{}) interface{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	old, ok := h.values[key]
+	if !ok && h.parent != nil {
+		old, _ = h.parent.Get(key)
+	}
+	h.values[key] = fn(old)
+}
+
+// 返回heap中的map，类型不符时返回nil，返回的map只读
+func GetHeapMap[V any](h *Heap, key string) map[string]V {
+	value, _ := h.Get(key)
+	result, _ := value.(map[string]V)
+	return result
+}
+
+// 复制key对应的map，设置name的值后写入当前scope
+func SetHeapMapValue[V any](h *Heap, key string, name string, value V) {
+	h.update(key, func(old interface{}) interface{} {
+		oldMap, _ := old.(map[string]V)
+		result := make(map[string]V, len(oldMap)+1)
+		for k, v := range oldMap {
+			result[k] = v
+		}
+		result[name] = value
+		return result
+	})
+}
+
+// 复制key对应的map，删除name后写入当前scope
+func DeleteHeapMapValue[V any](h *Heap, key string, name string) {
+	h.update(key, func(old interface{}) interface{} {
+		oldMap, _ := old.(map[string]V)
+		result := make(map[string]V, len(oldMap))
+		for k, v := range oldMap {
+			if k != name {
+				result[k] = v
+			}
+		}
+		return result
+	})
+}
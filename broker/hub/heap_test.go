@@ -0,0 +1,118 @@
+package hub
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHeapScope(t *testing.T) {
+	parent := NewHeap(map[string]interface{}{"a": 1, "b": 2})
+	child := parent.Scope()
+	child.Set("b", 20)
+	child.Set("c", 30)
+	parent.Set("d", 4)
+
+	tests := []struct {
+		heap  *Heap
+		key   string
+		value interface{}
+		ok    bool
+	}{
+		{child, "a", 1, true},
+		{child, "b", 20, true},
+		{child, "c", 30, true},
+		{child, "d", 4, true},
+		{parent, "b", 2, true},
+		{parent, "c", nil, false},
+	}
+	for _, tt := range tests {
+		if value, ok := tt.heap.Get(tt.key); value != tt.value || ok != tt.ok {
+			t.Errorf("Get(%q) = %v %v, want %v %v", tt.key, value, ok, tt.value, tt.ok)
+		}
+	}
+
+	//删除下层scope中的值后，上层scope中的同名值仍然可见
+	child.Delete("b")
+	if value, _ := child.Get("b"); value != 2 {
+		t.Errorf("Get(b) after Delete = %v", value)
+	}
+}
+
+func TestHeapSnapshotIsolation(t *testing.T) {
+	parent := NewHeap(nil)
+	SetHeapMapValue(parent, "vars", "x", "1")
+	child := parent.Scope()
+	SetHeapMapValue(child, "vars", "y", "2")
+
+	snapshot := child.Snapshot()
+	SetHeapMapValue(child, "vars", "x", "changed")
+	DeleteHeapMapValue[string](child, "vars", "y")
+	child.Set("z", 3)
+
+	tests := []struct {
+		name string
+		vars map[string]string
+		want map[string]string
+	}{
+		{"snapshot", snapshot["vars"].(map[string]string), map[string]string{"x": "1", "y": "2"}},
+		{"parent", GetHeapMap[string](parent, "vars"), map[string]string{"x": "1"}},
+		{"child", GetHeapMap[string](child, "vars"), map[string]string{"x": "changed"}},
+	}
+	for _, tt := range tests {
+		if len(tt.vars) != len(tt.want) {
+			t.Errorf("%s: vars = %v, want %v", tt.name, tt.vars, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if tt.vars[k] != v {
+				t.Errorf("%s: vars = %v, want %v", tt.name, tt.vars, tt.want)
+			}
+		}
+	}
+	if _, ok := snapshot["z"]; ok {
+		t.Errorf("snapshot = %v", snapshot)
+	}
+	if GetHeapMap[int](child, "vars") != nil {
+		t.Errorf("GetHeapMap with wrong type returned a map")
+	}
+}
+
+// 使用-race运行，检查并发读写、scope和snapshot之间没有数据竞争
+func TestHeapConcurrent(t *testing.T) {
+	const workers = 8
+	const count = 200
+	parent := NewHeap(nil)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			scope := parent.Scope()
+			name := strconv.Itoa(w)
+			for i := 0; i < count; i++ {
+				SetHeapMapValue(parent, "shared", name+"-"+strconv.Itoa(i), i)
+				SetHeapMapValue(scope, "own", strconv.Itoa(i), i)
+				parent.Set(name, i)
+				scope.Get(name)
+				for _, v := range scope.Snapshot() {
+					if m, ok := v.(map[string]int); ok {
+						for range m {
+						}
+					}
+				}
+			}
+			if own := GetHeapMap[int](scope, "own"); len(own) != count {
+				t.Errorf("worker %d: own = %d", w, len(own))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if shared := GetHeapMap[int](parent, "shared"); len(shared) != workers*count {
+		t.Fatalf("shared = %d, want %d", len(shared), workers*count)
+	}
+	if _, ok := parent.Get("own"); ok {
+		t.Fatalf("scope value leaked into parent")
+	}
+}
@@ -9,6 +9,16 @@ type HttpApiDefParam struct {
 	In    string       `json:"in"`
 	Name  string       `json:"name"`
 	Value BaseValueDef `json:"value,omitempty"`
+	File  *HttpFileDef `json:"file,omitempty"`
+}
+
+// multipart中的文件或者binary的内容，Source为base64、file或upload，value分别为base64编码的内容、本地文件路径和收到的请求中上传文件的字段名。
+// file的路径只能是Root目录下的相对路径
+type HttpFileDef struct {
+	Source      string `json:"source"`
+	Root        string `json:"root,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
 }
 
 type ApiCache struct {
@@ -29,4 +39,6 @@ type HttpApiDef struct {
 	RequestContentType string             `json:"requestContentType"`
 	Args               *[]HttpApiDefParam `json:"args"`
 	Cache              *ApiCache          `json:"cache"`
+	Client             string             `json:"client,omitempty"`
+	Envelope           bool               `json:"envelope,omitempty"`
 }
@@ -0,0 +1,29 @@
+package hub
+
+import "github.com/valyala/fasthttp"
+
+// 1次API调用，Args为解析后的参数，中间件可以修改
+type ApiCall struct {
+	Api      *ApiDef
+	Args     map[string]string
+	Internal bool
+	Attempt  int // 第几次执行，重试时递增，从1开始
+}
+
+type ApiCallHandler func(stack *Stack, call *ApiCall) (interface{}, int)
+
+// ApiRun的中间件，调用next执行后续的中间件和API，不调用next时直接返回自己的结果
+type ApiMiddleware func(stack *Stack, call *ApiCall, next ApiCallHandler) (interface{}, int)
+
+// 1次上游HTTP请求，Request在中间件返回后释放，不能保存
+type HttpCall struct {
+	Api      *HttpApiDef
+	Request  *fasthttp.Request
+	Internal bool
+}
+
+// 返回解析后的回复、状态码和错误，状态码不是200时result为回复的原始内容，HttpApiDef.Envelope时为回复的envelope
+type HttpCallHandler func(stack *Stack, call *HttpCall) (interface{}, int, error)
+
+// 上游HTTP请求的中间件，调用next发出请求，不调用next时直接返回自己的结果
+type HttpMiddleware func(stack *Stack, call *HttpCall, next HttpCallHandler) (interface{}, int, error)
@@ -2,6 +2,12 @@ package hub
 
 type ScheduleSwitchCaseDef struct {
 	Value         string            `json:"value"`
+	Values        []string          `json:"values,omitempty"`
+	Regex         string            `json:"regex,omitempty"`
+	Min           *float64          `json:"min,omitempty"`
+	Max           *float64          `json:"max,omitempty"`
+	Default       bool              `json:"default,omitempty"`
+	Fallthrough   bool              `json:"fallthrough,omitempty"`
 	ConcurrentNum int               `json:"concurrentNum,omitempty"`
 	Steps         *[]ScheduleApiDef `json:"steps"`
 }
@@ -13,21 +19,33 @@ type ScheduleControlDef struct {
 	Key               BaseValueDef             `json:"key"`
 	ConcurrentNum     int                      `json:"concurrentNum,omitempty"`
 	ConcurrentLoopNum int                      `json:"concurrentLoopNum,omitempty"`
+	JoinMode          string                   `json:"joinMode,omitempty"`
+	MaxIteration      int                      `json:"maxIteration,omitempty"`
+	Delay             int                      `json:"delay,omitempty"`
 	Cases             *[]ScheduleSwitchCaseDef `json:"cases,omitempty"`
 	Steps             *[]ScheduleApiDef        `json:"steps,omitempty"`
 }
 type ScheduleApiDef struct {
-	Type    string `json:"type"`
-	Mode    string `json:"mode"`
-	Private string `json:"private"`
+	Type      string    `json:"type"`
+	Mode      string    `json:"mode"`
+	Private   string    `json:"private"`
+	Retry     *RetryDef `json:"retry,omitempty"`
+	Id        string    `json:"id,omitempty"`
+	DependsOn []string  `json:"dependsOn,omitempty"`
 	/*只用于Api*/
-	Api     *ApiDef             `json:"api"`
-	Control *ScheduleControlDef `json:"control"`
+	Api        *ApiDef             `json:"api"`
+	Control    *ScheduleControlDef `json:"control"`
+	Compensate *[]ScheduleApiDef   `json:"compensate,omitempty"`
 }
 
 type ScheduleDef struct {
 	Name          string            `json:"name"`
 	Description   string            `json:"description"`
 	ConcurrentNum int               `json:"concurrentNum"`
+	Timeout       int               `json:"timeout,omitempty"`
+	JoinMode      string            `json:"joinMode,omitempty"`
+	Triggers      []TriggerDef      `json:"triggers,omitempty"`
 	Steps         *[]ScheduleApiDef `json:"steps"`
+	OnError       *[]ScheduleApiDef `json:"onError,omitempty"`
+	Finally       *[]ScheduleApiDef `json:"finally,omitempty"`
 }
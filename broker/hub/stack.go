@@ -1,6 +1,7 @@
 package hub
 
 import (
+	"context"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -8,7 +9,21 @@ import (
 
 type Stack struct {
 	GinContext *gin.Context
-	Heap       map[string]interface{}
+	Context    context.Context
+	Heap       *Heap
 	BaseString string
 	StartTime  time.Time
+	//当前正在记录的trace节点，为nil时不记录
+	Trace *TraceNode
+	//dry-run时httpApi只返回生成的请求，有副作用的API不执行
+	DryRun bool
+	//在flow或者schedule中执行，其中调用的flowApi按照scope决定是否使用独立的heap
+	Nested bool
+}
+
+// 复制stack并创建下层heap scope，写入heap的临时值不影响当前stack
+func (stack *Stack) Scope() *Stack {
+	result := *stack
+	result.Heap = stack.Heap.Scope()
+	return &result
 }
@@ -0,0 +1,17 @@
+package hub
+
+import "time"
+
+// 1次ApiRun的执行记录，flowApi和scheduleApi中的调用记录在Children中
+type TraceNode struct {
+	Command    string            `json:"command"`
+	Name       string            `json:"name"`
+	Args       map[string]string `json:"args,omitempty"`
+	Start      time.Time         `json:"start"`
+	Duration   float64           `json:"duration"`
+	Code       int               `json:"code"`
+	ResultSize int               `json:"resultSize"`
+	Attempts   int               `json:"attempts,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Children   []*TraceNode      `json:"children,omitempty"`
+}
@@ -0,0 +1,13 @@
+package hub
+
+type TriggerDef struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Schedule    string `json:"schedule"`
+	Private     string `json:"private"`
+	Cron        string `json:"cron,omitempty"`
+	Interval    int    `json:"interval,omitempty"`
+	Overlap     string `json:"overlap,omitempty"`
+	Jitter      int    `json:"jitter,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/jasony62/tms-go-apihub/hub"
 	"github.com/jasony62/tms-go-apihub/logger"
@@ -23,8 +25,15 @@ type confMap struct {
 	ApiRightMap      map[string]*hub.RightArray
 	FlowRightMap     map[string]*hub.RightArray
 	ScheduleRightMap map[string]*hub.RightArray
+	TriggerMap       map[string]*hub.TriggerDef
+	ClientMap        map[string]*hub.HttpClientDef
+	FileMap          map[int]map[string]string
+	LoadProblems     []hub.ConfProblem
 }
 
+// 运行时重新加载配置时保护DefaultConfMap，加载在新的map中进行，完成后在锁中替换
+var confLock sync.RWMutex
+
 var DefaultConfMap = confMap{
 	BasePath:         "./conf/",
 	ApiMap:           make(map[string]*hub.HttpApiDef),
@@ -35,22 +44,29 @@ var DefaultConfMap = confMap{
 	ApiRightMap:      make(map[string]*hub.RightArray),
 	FlowRightMap:     make(map[string]*hub.RightArray),
 	ScheduleRightMap: make(map[string]*hub.RightArray),
+	TriggerMap:       make(map[string]*hub.TriggerDef),
+	ClientMap:        make(map[string]*hub.HttpClientDef),
+	FileMap:          make(map[int]map[string]string),
 }
 
-func loadConfigJsonData(paths []string) {
+func loadConfigJsonData(conf *confMap, paths []string) {
 	logger.LogS().Infoln("加载API def文件...")
 	for i := hub.JSON_TYPE_PRIVATE; i <= hub.JSON_TYPE_SCHEDULE; i++ {
 		/*TODO add error return and panic if failure*/
-		loadJsonDefData(i, paths[i], "", true)
+		loadJsonDefData(conf, i, paths[i], "", true)
 	}
 
 	for i := hub.JSON_TYPE_API_RIGHT; i <= hub.JSON_TYPE_SCHEDULE_RIGHT; i++ {
 		/*TODO add error return and panic if failure*/
-		loadJsonDefData(i, paths[i], "", true)
+		loadJsonDefData(conf, i, paths[i], "", true)
 	}
+
+	loadJsonDefData(conf, hub.JSON_TYPE_TRIGGER, paths[hub.JSON_TYPE_TRIGGER], "", true)
+	loadJsonDefData(conf, hub.JSON_TYPE_CLIENT, paths[hub.JSON_TYPE_CLIENT], "", true)
 }
 
-func loadJsonDefData(jsonType int, path string, prefix string, includeDir bool) {
+// 加载的定义保存到conf中
+func loadJsonDefData(conf *confMap, jsonType int, path string, prefix string, includeDir bool) {
 	fileInfoList, err := ioutil.ReadDir(path)
 	if err != nil {
 		logger.LogS().Errorln(err.Error())
@@ -63,7 +79,7 @@ func loadJsonDefData(jsonType int, path string, prefix string, includeDir bool)
 
 		if fileInfoList[i].IsDir() && includeDir {
 			prefix = fileInfoList[i].Name()
-			loadJsonDefData(jsonType, path+"/"+prefix, prefix, true)
+			loadJsonDefData(conf, jsonType, path+"/"+prefix, prefix, true)
 		} else {
 			if !strings.HasSuffix(fileName, ".json") {
 				continue
@@ -71,19 +87,6 @@ func loadJsonDefData(jsonType int, path string, prefix string, includeDir bool)
 
 			prefix = oldPrefix
 
-			byteFile, err := ioutil.ReadFile(fileName)
-			if err != nil {
-				str := "获得Json定义失败：" + err.Error()
-				logger.LogS().Errorln(str)
-				panic(str)
-			}
-
-			if !json.Valid(byteFile) {
-				str := "Json文件无效：" + fileName
-				logger.LogS().Errorln(str)
-				panic(str)
-			}
-
 			var key string
 			fname := fileInfoList[i].Name()
 			index := strings.Index(fname, ".json")
@@ -93,43 +96,96 @@ func loadJsonDefData(jsonType int, path string, prefix string, includeDir bool)
 
 			key = fname
 
+			byteFile, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				addLoadProblem(conf, fileName, key, NewStatusError(hub.TmsErrorLoadId, http.StatusInternalServerError, "获得Json定义失败", err))
+				continue
+			}
+
+			if !json.Valid(byteFile) {
+				addLoadProblem(conf, fileName, key, NewStatusError(hub.TmsErrorLoadId, http.StatusInternalServerError, "Json文件无效", nil))
+				continue
+			}
+
+			//解析失败的定义只记录问题，不加入map中
 			decoder := json.NewDecoder(bytes.NewReader(byteFile))
 			switch jsonType {
 			case hub.JSON_TYPE_API:
 				def := new(hub.HttpApiDef)
-				decoder.Decode(&def)
-				DefaultConfMap.ApiMap[key] = def
+				if err = decoder.Decode(&def); err == nil {
+					checkHttpApiMethod(conf, fileName, key, def)
+					conf.ApiMap[key] = def
+				}
 			case hub.JSON_TYPE_FLOW:
 				def := new(hub.FlowDef)
-				decoder.Decode(&def)
-				DefaultConfMap.FlowMap[key] = def
+				if err = decoder.Decode(&def); err == nil {
+					conf.FlowMap[key] = def
+				}
 			case hub.JSON_TYPE_SCHEDULE:
 				def := new(hub.ScheduleDef)
-				decoder.Decode(&def)
-				DefaultConfMap.ScheduleMap[key] = def
+				if err = decoder.Decode(&def); err == nil {
+					checkScheduleDags(conf, fileName, key, def)
+					conf.ScheduleMap[key] = def
+				}
 			case hub.JSON_TYPE_PRIVATE:
 				def := new(hub.PrivateArray)
-				decoder.Decode(&def)
-				DefaultConfMap.PrivateMap[key] = def
+				if err = decoder.Decode(&def); err == nil {
+					conf.PrivateMap[key] = def
+				}
 			case hub.JSON_TYPE_API_RIGHT:
 				def := new(hub.RightArray)
-				decoder.Decode(&def)
-				DefaultConfMap.ApiRightMap[key] = def
+				if err = decoder.Decode(&def); err == nil {
+					conf.ApiRightMap[key] = def
+				}
 			case hub.JSON_TYPE_FLOW_RIGHT:
 				def := new(hub.RightArray)
-				decoder.Decode(&def)
-				DefaultConfMap.FlowRightMap[key] = def
+				if err = decoder.Decode(&def); err == nil {
+					conf.FlowRightMap[key] = def
+				}
 			case hub.JSON_TYPE_SCHEDULE_RIGHT:
 				def := new(hub.RightArray)
-				decoder.Decode(&def)
-				DefaultConfMap.ScheduleRightMap[key] = def
+				if err = decoder.Decode(&def); err == nil {
+					conf.ScheduleRightMap[key] = def
+				}
+			case hub.JSON_TYPE_TRIGGER:
+				def := new(hub.TriggerDef)
+				if err = decoder.Decode(&def); err == nil {
+					if len(def.Name) == 0 {
+						def.Name = key
+					}
+					conf.TriggerMap[key] = def
+				}
+			case hub.JSON_TYPE_CLIENT:
+				def := new(hub.HttpClientDef)
+				if err = decoder.Decode(&def); err == nil {
+					if len(def.Name) == 0 {
+						def.Name = key
+					}
+					conf.ClientMap[key] = def
+				}
 			default:
 			}
+
+			if err != nil {
+				str := "解析Json定义失败：" + err.Error()
+				logger.LogS().Errorln(fileName, str)
+				conf.LoadProblems = append(conf.LoadProblems, hub.ConfProblem{File: fileName, Name: key, Message: str})
+			}
+			if conf.FileMap[jsonType] == nil {
+				conf.FileMap[jsonType] = make(map[string]string)
+			}
+			conf.FileMap[jsonType][key] = fileName
 		}
 	}
 }
 
-func loadTemplateData(path string, prefix string) {
+// 文件无法读取或解析时记录下来并跳过，不影响其他文件的加载
+func addLoadProblem(conf *confMap, fileName string, key string, err *hub.StatusError) {
+	logger.LogS().Errorln(fileName, err.Error())
+	conf.LoadProblems = append(conf.LoadProblems, hub.ConfProblem{File: fileName, Name: key, Message: err.Error()})
+}
+
+func loadTemplateData(conf *confMap, path string, prefix string) {
 	logger.LogS().Infoln("加载Template文件...")
 	fileInfoList, err := ioutil.ReadDir(path)
 	if err != nil {
@@ -144,19 +200,18 @@ func loadTemplateData(path string, prefix string) {
 
 		if fileInfoList[i].IsDir() {
 			prefix = fileInfoList[i].Name()
-			loadTemplateData(path+"/"+prefix, prefix)
+			loadTemplateData(conf, path+"/"+prefix, prefix)
 		} else {
 			prefix = oldPrefix
 
+			fname := fileInfoList[i].Name()
 			byteFile, err := ioutil.ReadFile(fileName)
 			if err != nil {
-				str := "获得tmpl定义失败：" + err.Error()
-				logger.LogS().Errorln(str)
-				panic(str)
+				addLoadProblem(conf, fileName, fname, NewStatusError(hub.TmsErrorLoadId, http.StatusInternalServerError, "获得tmpl定义失败", err))
+				continue
 			}
 
-			fname := fileInfoList[i].Name()
-			DefaultConfMap.SourceMap[fname] = string(byteFile)
+			conf.SourceMap[fname] = string(byteFile)
 		}
 	}
 }
@@ -166,30 +221,51 @@ func FindHttpApiDef(name string) (value *hub.HttpApiDef, ok bool) {
 		return nil, false
 	}
 
+	confLock.RLock()
+	defer confLock.RUnlock()
 	value, ok = DefaultConfMap.ApiMap[name]
 	return
 }
 
+func FindHttpClientDef(name string) (value *hub.HttpClientDef, ok bool) {
+	if len(name) == 0 {
+		return nil, false
+	}
+
+	confLock.RLock()
+	defer confLock.RUnlock()
+	value, ok = DefaultConfMap.ClientMap[name]
+	return
+}
+
 func FindPrivateDef(name string) (value *hub.PrivateArray, ok bool) {
 	if len(name) == 0 {
 		return nil, false
 	}
 
+	confLock.RLock()
+	defer confLock.RUnlock()
 	value, ok = DefaultConfMap.PrivateMap[name]
 	return
 }
 
 func FindFlowDef(id string) (value *hub.FlowDef, ok bool) {
+	confLock.RLock()
+	defer confLock.RUnlock()
 	value, ok = DefaultConfMap.FlowMap[id]
 	return
 }
 
 func FindScheduleDef(id string) (value *hub.ScheduleDef, ok bool) {
+	confLock.RLock()
+	defer confLock.RUnlock()
 	value, ok = DefaultConfMap.ScheduleMap[id]
 	return
 }
 
 func FindResourceDef(id string) (value string, ok bool) {
+	confLock.RLock()
+	defer confLock.RUnlock()
 	value, ok = DefaultConfMap.SourceMap[id]
 	return
 }
@@ -197,6 +273,8 @@ func FindResourceDef(id string) (value string, ok bool) {
 func FindRightDef(user string, name string, callType string) *hub.RightArray {
 	// check是否有权限
 	logger.LogS().Infoln("CheckRight user:", user, " callType:", callType, " name:", name)
+	confLock.RLock()
+	defer confLock.RUnlock()
 	//map
 	switch callType {
 	case "httpapi":
@@ -211,17 +289,122 @@ func FindRightDef(user string, name string, callType string) *hub.RightArray {
 	}
 }
 
+// 重新加载triggers目录，并收集schedule中定义的trigger。
+// 在新的map中加载后再替换，之前triggers目录的问题被新加载的问题代替。
+func LoadTriggerConf() map[string]*hub.TriggerDef {
+	path := DefaultConfMap.BasePath + "triggers"
+	conf := &confMap{TriggerMap: make(map[string]*hub.TriggerDef), FileMap: make(map[int]map[string]string)}
+	loadJsonDefData(conf, hub.JSON_TYPE_TRIGGER, path, "", true)
+
+	confLock.Lock()
+	DefaultConfMap.TriggerMap = conf.TriggerMap
+	fileMap := make(map[int]map[string]string, len(DefaultConfMap.FileMap))
+	for jsonType, files := range DefaultConfMap.FileMap {
+		fileMap[jsonType] = files
+	}
+	fileMap[hub.JSON_TYPE_TRIGGER] = conf.FileMap[hub.JSON_TYPE_TRIGGER]
+	DefaultConfMap.FileMap = fileMap
+	problems := make([]hub.ConfProblem, 0, len(DefaultConfMap.LoadProblems)+len(conf.LoadProblems))
+	for _, problem := range DefaultConfMap.LoadProblems {
+		if !strings.HasPrefix(problem.File, path+"/") {
+			problems = append(problems, problem)
+		}
+	}
+	DefaultConfMap.LoadProblems = append(problems, conf.LoadProblems...)
+	confLock.Unlock()
+	return GetTriggerDefs()
+}
+
+// 返回triggers目录和schedule中定义的所有trigger，schedule中的trigger名称为"schedule名称.trigger名称"
+func GetTriggerDefs() map[string]*hub.TriggerDef {
+	confLock.RLock()
+	defer confLock.RUnlock()
+
+	result := make(map[string]*hub.TriggerDef, len(DefaultConfMap.TriggerMap))
+	for k, v := range DefaultConfMap.TriggerMap {
+		result[k] = v
+	}
+
+	for scheduleName, scheduleDef := range DefaultConfMap.ScheduleMap {
+		for i := range scheduleDef.Triggers {
+			def := scheduleDef.Triggers[i]
+			def.Schedule = scheduleName
+			if len(def.Name) == 0 {
+				def.Name = strconv.Itoa(i)
+			}
+			def.Name = scheduleName + "." + def.Name
+			result[def.Name] = &def
+		}
+	}
+	return result
+}
+
+// 返回定义所在的文件
+func GetConfFile(jsonType int, key string) string {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	return DefaultConfMap.FileMap[jsonType][key]
+}
+
+// 返回加载配置时解析失败的文件
+func GetLoadProblems() []hub.ConfProblem {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	return append([]hub.ConfProblem{}, DefaultConfMap.LoadProblems...)
+}
+
+// 返回当前配置，重新加载时替换的是map，返回的map不会再被修改
+func GetConfSnapshot() confMap {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	return DefaultConfMap
+}
+
 func GetBasePath() string {
+	confLock.RLock()
+	defer confLock.RUnlock()
 	return DefaultConfMap.BasePath
 }
 
+func copyMap[V any](src map[string]V) map[string]V {
+	dst := make(map[string]V, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// 在当前配置的副本中加载，完成后整体替换，之前加载的定义仍然保留，LoadProblems只包含本次加载的问题
 func LoadConf(basePath string) {
-	loadConfigJsonData([]string{basePath + "privates",
+	current := GetConfSnapshot()
+	conf := &confMap{
+		BasePath:         current.BasePath,
+		ApiMap:           copyMap(current.ApiMap),
+		PrivateMap:       copyMap(current.PrivateMap),
+		FlowMap:          copyMap(current.FlowMap),
+		ScheduleMap:      copyMap(current.ScheduleMap),
+		SourceMap:        copyMap(current.SourceMap),
+		ApiRightMap:      copyMap(current.ApiRightMap),
+		FlowRightMap:     copyMap(current.FlowRightMap),
+		ScheduleRightMap: copyMap(current.ScheduleRightMap),
+		TriggerMap:       copyMap(current.TriggerMap),
+		ClientMap:        copyMap(current.ClientMap),
+		FileMap:          make(map[int]map[string]string, len(current.FileMap)),
+	}
+	for jsonType, files := range current.FileMap {
+		conf.FileMap[jsonType] = copyMap(files)
+	}
+	loadConfigJsonData(conf, []string{basePath + "privates",
 		basePath + "httpapis", basePath + "flows",
 		basePath + "schedules", basePath + "rights/httpapi",
-		basePath + "rights/flow", basePath + "rights/schedule"})
+		basePath + "rights/flow", basePath + "rights/schedule",
+		basePath + "triggers", basePath + "clients"})
+	loadTemplateData(conf, basePath+"templates", "")
+
+	confLock.Lock()
+	DefaultConfMap = *conf
+	confLock.Unlock()
 
-	loadTemplateData(basePath+"templates", "")
 	loadConfigPluginData(basePath + "plugins")
 }
 
@@ -233,6 +416,6 @@ func LoadMainFlow(path string) (interface{}, int) {
 		DefaultConfMap.BasePath = path
 	}
 	logger.LogS().Infoln("Load main flow from %s\n", DefaultConfMap.BasePath)
-	loadJsonDefData(hub.JSON_TYPE_FLOW, DefaultConfMap.BasePath, "", false)
+	loadJsonDefData(&DefaultConfMap, hub.JSON_TYPE_FLOW, DefaultConfMap.BasePath, "", false)
 	return nil, http.StatusOK
 }
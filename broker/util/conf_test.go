@@ -0,0 +1,128 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadTriggerConfReplacesProblems(t *testing.T) {
+	saved := GetConfSnapshot()
+	t.Cleanup(func() { DefaultConfMap = saved })
+	DefaultConfMap.BasePath = t.TempDir() + "/"
+	writeTestFile(t, DefaultConfMap.BasePath+"triggers/good.json", `{"schedule": "s", "interval": 60}`)
+	writeTestFile(t, DefaultConfMap.BasePath+"triggers/bad.json", `{"schedule": 1}`)
+
+	for i := 0; i < 2; i++ {
+		defs := LoadTriggerConf()
+		if defs["good"] == nil || defs["good"].Name != "good" {
+			t.Fatalf("triggers = %v", defs)
+		}
+		count := 0
+		for _, problem := range GetLoadProblems() {
+			if problem.Name == "bad" {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Fatalf("load %d: problems = %v", i, GetLoadProblems())
+		}
+	}
+	if len(GetConfFile(hub.JSON_TYPE_TRIGGER, "good")) == 0 {
+		t.Fatal("trigger file not recorded")
+	}
+}
+
+func TestLoadTriggerConfConcurrent(t *testing.T) {
+	saved := GetConfSnapshot()
+	t.Cleanup(func() { DefaultConfMap = saved })
+	DefaultConfMap.BasePath = t.TempDir() + "/"
+	writeTestFile(t, DefaultConfMap.BasePath+"triggers/a.json", `{"schedule": "s", "interval": 60}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			LoadTriggerConf()
+		}()
+		go func() {
+			defer wg.Done()
+			GetTriggerDefs()
+			GetLoadProblems()
+			GetConfFile(hub.JSON_TYPE_TRIGGER, "a")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLoadJsonDefDataSkipsInvalidDefs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir+"/good.json", `{"name": "good", "steps": []}`)
+	writeTestFile(t, dir+"/bad.json", `{"name": "bad", "steps": {}}`)
+
+	conf := &confMap{FlowMap: make(map[string]*hub.FlowDef), FileMap: make(map[int]map[string]string)}
+	loadJsonDefData(conf, hub.JSON_TYPE_FLOW, dir, "", true)
+	if conf.FlowMap["good"] == nil {
+		t.Fatal("good flow not loaded")
+	}
+	if _, ok := conf.FlowMap["bad"]; ok {
+		t.Fatal("half-decoded flow registered")
+	}
+	if len(conf.LoadProblems) != 1 || conf.LoadProblems[0].Name != "bad" {
+		t.Fatalf("problems = %v", conf.LoadProblems)
+	}
+}
+
+func TestLoadConfConcurrent(t *testing.T) {
+	saved := GetConfSnapshot()
+	t.Cleanup(func() { DefaultConfMap = saved })
+	DefaultConfMap.BasePath = t.TempDir() + "/"
+	writeTestFile(t, DefaultConfMap.BasePath+"flows/a.json", `{"name": "a", "steps": []}`)
+	writeTestFile(t, DefaultConfMap.BasePath+"flows/bad.json", `{"name": "bad", "steps": {}}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			LoadConf(GetBasePath())
+		}()
+		go func() {
+			defer wg.Done()
+			FindFlowDef("a")
+			GetLoadProblems()
+			GetConfFile(hub.JSON_TYPE_FLOW, "a")
+			for range GetConfSnapshot().FlowMap {
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := FindFlowDef("a"); !ok {
+		t.Fatal("flow not loaded")
+	}
+	//重新加载时之前的问题被代替
+	count := 0
+	for _, problem := range GetLoadProblems() {
+		if problem.Name == "bad" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("problems = %v", GetLoadProblems())
+	}
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+)
+
+// steps中任意一个定义了dependsOn时，按照依赖关系执行
+func IsScheduleDag(tasks []hub.ScheduleApiDef) bool {
+	for i := range tasks {
+		if len(tasks[i].DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 根据id和dependsOn计算依赖关系，deps[i]为第i个step依赖的step序号，id重复、依赖不存在或者有环时返回错误
+func GetScheduleDag(tasks []hub.ScheduleApiDef) ([][]int, error) {
+	ids := make(map[string]int, len(tasks))
+	for i := range tasks {
+		id := tasks[i].Id
+		if len(id) == 0 {
+			continue
+		}
+		if _, ok := ids[id]; ok {
+			return nil, errors.New("id重复：" + id)
+		}
+		ids[id] = i
+	}
+
+	deps := make([][]int, len(tasks))
+	for i := range tasks {
+		for _, name := range tasks[i].DependsOn {
+			index, ok := ids[name]
+			if !ok {
+				return nil, errors.New("第" + strconv.Itoa(i) + "个step依赖的id不存在：" + name)
+			}
+			deps[i] = append(deps[i], index)
+		}
+	}
+
+	if cycle := findDagCycle(deps); cycle != nil {
+		names := make([]string, len(cycle))
+		for i, index := range cycle {
+			names[i] = GetScheduleTaskId(tasks, index)
+		}
+		return nil, errors.New("dependsOn存在循环依赖：" + strings.Join(names, " -> "))
+	}
+	return deps, nil
+}
+
+// 返回step的id，没有id时返回序号
+func GetScheduleTaskId(tasks []hub.ScheduleApiDef, index int) string {
+	if len(tasks[index].Id) > 0 {
+		return tasks[index].Id
+	}
+	return "[" + strconv.Itoa(index) + "]"
+}
+
+// 深度优先查找环，返回环上的序号，首尾相同
+func findDagCycle(deps [][]int) []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(deps))
+	var path []int
+	var visit func(int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		path = append(path, i)
+		for _, dep := range deps[i] {
+			switch state[dep] {
+			case visiting:
+				for start := range path {
+					if path[start] == dep {
+						return append(append([]int{}, path[start:]...), dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range deps {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// 加载时检查schedule中所有steps的依赖关系
+func checkScheduleDags(conf *confMap, fileName string, key string, def *hub.ScheduleDef) {
+	var check func(location string, tasks *[]hub.ScheduleApiDef)
+	check = func(location string, tasks *[]hub.ScheduleApiDef) {
+		if tasks == nil {
+			return
+		}
+		if _, err := GetScheduleDag(*tasks); err != nil {
+			str := "检查Schedule依赖失败：" + location + "，" + err.Error()
+			logger.LogS().Errorln(fileName, str)
+			conf.LoadProblems = append(conf.LoadProblems, hub.ConfProblem{File: fileName, Name: key, Location: location, Message: err.Error()})
+		}
+		for i := range *tasks {
+			taskLocation := location + "[" + strconv.Itoa(i) + "]"
+			check(taskLocation+".compensate", (*tasks)[i].Compensate)
+			control := (*tasks)[i].Control
+			if control == nil {
+				continue
+			}
+			controlLocation := taskLocation + ".control"
+			check(controlLocation+".steps", control.Steps)
+			if control.Cases != nil {
+				for j := range *control.Cases {
+					check(controlLocation+".cases["+strconv.Itoa(j)+"].steps", (*control.Cases)[j].Steps)
+				}
+			}
+		}
+	}
+
+	check("steps", def.Steps)
+	check("onError", def.OnError)
+	check("finally", def.Finally)
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func newTestDagTask(id string, dependsOn ...string) hub.ScheduleApiDef {
+	return hub.ScheduleApiDef{Type: "api", Id: id, DependsOn: dependsOn}
+}
+
+func TestGetScheduleDag(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []hub.ScheduleApiDef
+		deps  [][]int
+		err   string
+	}{
+		{"no deps", []hub.ScheduleApiDef{newTestDagTask("a"), newTestDagTask("")}, [][]int{nil, nil}, ""},
+		{"diamond", []hub.ScheduleApiDef{newTestDagTask("d", "b", "c"), newTestDagTask("b", "a"), newTestDagTask("c", "a"), newTestDagTask("a")},
+			[][]int{{1, 2}, {3}, {3}, nil}, ""},
+		{"duplicate id", []hub.ScheduleApiDef{newTestDagTask("a"), newTestDagTask("a")}, nil, "id重复：a"},
+		{"missing dep", []hub.ScheduleApiDef{newTestDagTask("a", "x")}, nil, "依赖的id不存在：x"},
+		{"self cycle", []hub.ScheduleApiDef{newTestDagTask("a", "a")}, nil, "a -> a"},
+		{"cycle", []hub.ScheduleApiDef{newTestDagTask("a", "c"), newTestDagTask("b", "a"), newTestDagTask("c", "b"), newTestDagTask("d")}, nil, "a -> c -> b -> a"},
+		{"cycle after dag", []hub.ScheduleApiDef{newTestDagTask("a"), newTestDagTask("b", "a", "c"), newTestDagTask("c", "b")}, nil, "b -> c -> b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps, err := GetScheduleDag(tt.tasks)
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deps) != len(tt.deps) {
+				t.Fatalf("deps = %v, want %v", deps, tt.deps)
+			}
+			for i := range deps {
+				if len(deps[i]) != len(tt.deps[i]) {
+					t.Fatalf("deps = %v, want %v", deps, tt.deps)
+				}
+				for j := range deps[i] {
+					if deps[i][j] != tt.deps[i][j] {
+						t.Fatalf("deps = %v, want %v", deps, tt.deps)
+					}
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"encoding/base64"
+	"errors"
 	"runtime"
 
 	"github.com/jasony62/tms-go-apihub/hub"
@@ -16,4 +17,28 @@ func CreateTmsError(id uint, msg string, err error) (ret hub.TmsError) {
 	ret.Error = err
 	/*TODO add to heap?*/
 	return
-}
\ No newline at end of file
+}
+
+// 创建可以作为error返回的TmsError
+func NewStatusError(id uint, code int, msg string, err error) *hub.StatusError {
+	ret := &hub.StatusError{Code: code}
+	_, ret.Module, ret.Line, _ = runtime.Caller(1)
+	ret.Module = base64.StdEncoding.EncodeToString([]byte(ret.Module))
+	ret.Id = id
+	ret.ErrorMsg = msg
+	ret.TmsError.Error = err
+	return ret
+}
+
+// 将error转为API的返回结果，err中包含StatusError时使用其中的TmsError和状态码，否则用id和code创建新的TmsError
+func ErrorResult(id uint, code int, msg string, err error) (interface{}, int) {
+	var statusErr *hub.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.TmsError, statusErr.Code
+	}
+
+	ret := hub.TmsError{Id: id, ErrorMsg: msg, Error: err}
+	_, ret.Module, ret.Line, _ = runtime.Caller(1)
+	ret.Module = base64.StdEncoding.EncodeToString([]byte(ret.Module))
+	return ret, code
+}
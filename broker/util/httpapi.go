@@ -0,0 +1,43 @@
+package util
+
+import (
+	"strings"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+	"github.com/jasony62/tms-go-apihub/logger"
+)
+
+// HTTPAPI支持的请求方法，值表示是否发送body
+var httpMethods = map[string]bool{
+	"GET":     false,
+	"HEAD":    false,
+	"OPTIONS": false,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+}
+
+func IsHttpMethodSupported(method string) bool {
+	_, ok := httpMethods[method]
+	return ok
+}
+
+func IsHttpMethodWithBody(method string) bool {
+	return httpMethods[method]
+}
+
+// 加载时把method转为大写，并检查是否支持
+func checkHttpApiMethod(conf *confMap, fileName string, key string, def *hub.HttpApiDef) {
+	def.Method = strings.ToUpper(def.Method)
+	if IsHttpMethodSupported(def.Method) {
+		return
+	}
+
+	str := "不支持的method：" + def.Method
+	if len(def.Method) == 0 {
+		str = "缺少method"
+	}
+	logger.LogS().Errorln(fileName, str)
+	conf.LoadProblems = append(conf.LoadProblems, hub.ConfProblem{File: fileName, Name: key, Location: "method", Message: str})
+}
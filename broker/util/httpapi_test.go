@@ -0,0 +1,55 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func TestHttpMethods(t *testing.T) {
+	tests := []struct {
+		method    string
+		supported bool
+		withBody  bool
+	}{
+		{"GET", true, false},
+		{"HEAD", true, false},
+		{"OPTIONS", true, false},
+		{"POST", true, true},
+		{"PUT", true, true},
+		{"PATCH", true, true},
+		{"DELETE", true, true},
+		{"TRACE", false, false},
+		{"post", false, false},
+	}
+	for _, tt := range tests {
+		if IsHttpMethodSupported(tt.method) != tt.supported || IsHttpMethodWithBody(tt.method) != tt.withBody {
+			t.Errorf("method %s: supported = %v, withBody = %v", tt.method, IsHttpMethodSupported(tt.method), IsHttpMethodWithBody(tt.method))
+		}
+	}
+}
+
+func TestCheckHttpApiMethod(t *testing.T) {
+	tests := []struct {
+		method  string
+		want    string
+		problem string
+	}{
+		{"put", "PUT", ""},
+		{"Patch", "PATCH", ""},
+		{"", "", "缺少method"},
+		{"trace", "TRACE", "不支持的method：TRACE"},
+	}
+	for _, tt := range tests {
+		conf := &confMap{}
+		def := &hub.HttpApiDef{Method: tt.method}
+		checkHttpApiMethod(conf, "test.json", "test", def)
+		if def.Method != tt.want {
+			t.Errorf("method %q = %q, want %q", tt.method, def.Method, tt.want)
+		}
+		if len(tt.problem) == 0 && len(conf.LoadProblems) != 0 ||
+			len(tt.problem) > 0 && (len(conf.LoadProblems) != 1 || conf.LoadProblems[0].Message != tt.problem || conf.LoadProblems[0].Location != "method") {
+			t.Errorf("method %q: problems = %v", tt.method, conf.LoadProblems)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
 	"os"
+
+	"github.com/jasony62/tms-go-apihub/hub"
 )
 
 func PathExists(path string) (bool, error) {
@@ -24,3 +27,11 @@ func CreateBaseString(param map[string]interface{}) string {
 	result = append(result, dataType...)
 	return string(result)
 }
+
+// 获得stack中的context，没有设置时返回context.Background()
+func GetContext(stack *hub.Stack) context.Context {
+	if stack == nil || stack.Context == nil {
+		return context.Background()
+	}
+	return stack.Context
+}
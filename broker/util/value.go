@@ -3,7 +3,10 @@ package util
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -11,7 +14,7 @@ import (
 	"github.com/jasony62/tms-go-apihub/logger"
 )
 
-func executeTemplate(source interface{}, rules interface{}) (*bytes.Buffer, error) {
+func parseJsonTemplate(rules interface{}) (*template.Template, error) {
 	byteTempl, err := json.Marshal(rules)
 	if err != nil {
 		return nil, err
@@ -29,6 +32,14 @@ func executeTemplate(source interface{}, rules interface{}) (*bytes.Buffer, erro
 		logger.LogS().Infoln("get template result：", strTempl, byteTempl, " error: ", err)
 		return nil, err
 	}
+	return tmpl, nil
+}
+
+func executeTemplate(source interface{}, rules interface{}) (*bytes.Buffer, error) {
+	tmpl, err := parseJsonTemplate(rules)
+	if err != nil {
+		return nil, err
+	}
 	buf := new(bytes.Buffer)
 	err = tmpl.Execute(buf, source)
 	if err != nil {
@@ -55,6 +66,21 @@ func rvemoveOutideQuote(s []byte) string {
 	return string(s)
 }
 
+// 只检查模板语法，不执行
+func CheckTemplate(content string) error {
+	_, err := template.New("key").Funcs(funcMapForTemplate).Parse(content)
+	return err
+}
+
+func CheckJsonTemplate(rules interface{}) error {
+	_, err := parseJsonTemplate(rules)
+	return err
+}
+
+func HasFunc(name string) bool {
+	return funcMap[name] != nil
+}
+
 // 从执行结果中获取查询参数
 func queryFromHeap(stack *hub.Stack, name string) (string, error) {
 	tmpl, err := template.New("key").Funcs(funcMapForTemplate).Parse(name)
@@ -63,9 +89,10 @@ func queryFromHeap(stack *hub.Stack, name string) (string, error) {
 		return "", err
 	}
 	buf := new(bytes.Buffer)
-	err = tmpl.Execute(buf, stack.Heap)
+	heap := stack.Heap.Snapshot()
+	err = tmpl.Execute(buf, heap)
 	if err != nil {
-		logger.LogS().Errorln("NOK execute template:", err, " name:", name, "heap:", stack.Heap)
+		logger.LogS().Errorln("NOK execute template:", err, " name:", name, "heap:", heap)
 		return "", err
 	}
 	if buf.String() == "<no value>" {
@@ -74,6 +101,34 @@ func queryFromHeap(stack *hub.Stack, name string) (string, error) {
 	return buf.String(), err
 }
 
+// 按照"a.b.0"格式的路径从heap中获取原始值，不进行字符串转换
+func GetHeapRawValue(stack *hub.Stack, path string) (interface{}, error) {
+	var value interface{} = stack.Heap.Snapshot()
+	for _, name := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		if len(name) == 0 {
+			continue
+		}
+
+		switch current := value.(type) {
+		case map[string]interface{}:
+			value = current[name]
+		case map[string]string:
+			value = current[name]
+		case map[string]int:
+			value = current[name]
+		case []interface{}:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, errors.New("无效的数组索引：" + path)
+			}
+			value = current[index]
+		default:
+			return nil, errors.New("无效的heap路径：" + path)
+		}
+	}
+	return value, nil
+}
+
 func findPrivateValue(private *hub.PrivateArray, name string) string {
 	if private == nil {
 		return ""
@@ -88,7 +143,7 @@ func findPrivateValue(private *hub.PrivateArray, name string) string {
 }
 
 func getArgsVal(stepResult map[string]interface{}, args []string) []string {
-	vars := (stepResult["vars"]).(map[string]string)
+	vars, _ := (stepResult["vars"]).(map[string]string)
 	argsV := []string{}
 	for _, v := range args {
 		argsV = append(argsV, vars[v])
@@ -96,25 +151,38 @@ func getArgsVal(stepResult map[string]interface{}, args []string) []string {
 	return argsV
 }
 
+// 后台job和trigger中没有收到的请求
+func noRequestError(from *hub.BaseValueDef) error {
+	str := "没有收到的请求，无法获得" + from.From + "：" + from.Content
+	logger.LogS().Errorln(str)
+	return NewStatusError(hub.TmsErrorNoRequestId, http.StatusInternalServerError, str, nil)
+}
+
 func GetParameterRawValue(stack *hub.Stack, private *hub.PrivateArray, from *hub.BaseValueDef) (value interface{}, err error) {
 	switch from.From {
 	case "literal":
 		value = from.Content
 	case "header":
+		if stack.GinContext == nil {
+			return "", noRequestError(from)
+		}
 		value = stack.GinContext.GetHeader(from.Content)
 	case "query":
+		if stack.GinContext == nil {
+			return "", noRequestError(from)
+		}
 		// 从请求参数中获取查询参数
 		value = stack.GinContext.Query(from.Content)
 	case hub.HeapOriginName:
-		value, err = queryFromHeap(stack, "{{.origin."+from.Content+"}}")
+		value, err = GetHeapRawValue(stack, hub.HeapOriginName+"."+from.Content)
 	case "private":
 		value = findPrivateValue(private, from.Content)
 	case "template":
 		value, err = queryFromHeap(stack, from.Content)
 	case "heap":
-		value, err = queryFromHeap(stack, "{{."+from.Content+"}}")
+		value, err = GetHeapRawValue(stack, from.Content)
 	case "json":
-		jsonOutBody, err := json2Json(stack.Heap, from.Json)
+		jsonOutBody, err := json2Json(stack.Heap.Snapshot(), from.Json)
 		if err != nil {
 			return "", err
 		}
@@ -124,34 +192,66 @@ func GetParameterRawValue(stack *hub.Stack, private *hub.PrivateArray, from *hub
 		}
 		value = rvemoveOutideQuote(byteJson)
 	case "jsonRaw":
-		value, err = json2Json(stack.Heap, from.Json)
+		value, err = json2Json(stack.Heap.Snapshot(), from.Json)
 	case "env":
 		value = os.Getenv(from.Content)
 	case "func":
 		function := funcMap[from.Content]
 		if function == nil {
-			str := "获取function定义失败："
+			str := "获取function定义失败：" + from.Content
 			logger.LogS().Errorln(str)
-			panic(str)
+			return "", NewStatusError(hub.TmsErrorFuncId, http.StatusInternalServerError, str, nil)
 		}
 		var params []string
 		if len(from.Args) > 0 {
 			strs := strings.Fields(from.Args)
-			params = getArgsVal(stack.Heap, strs)
+			params = getArgsVal(stack.Heap.Snapshot(), strs)
 		}
 		value = function(params)
 	default:
 		str := "不支持的type " + from.From
 		logger.LogS().Errorln(str)
-		panic(str)
+		return "", NewStatusError(hub.TmsErrorUnknownFromId, http.StatusInternalServerError, str, nil)
 	}
 	return
 }
 
+// 根据heap计算条件模板，结果为空、false、0或<no value>时认为条件不成立
+func CheckCondition(stack *hub.Stack, cond string) (bool, error) {
+	result, err := queryFromHeap(stack, cond)
+	if err != nil {
+		return false, err
+	}
+	return IsConditionTrue(result), nil
+}
+
+func IsConditionTrue(value string) bool {
+	switch strings.TrimSpace(value) {
+	case "", "false", "0", "<no value>":
+		return false
+	default:
+		return true
+	}
+}
+
+// origin和heap按照模板转换为字符串，其他来源取原始值
 func GetParameterStringValue(stack *hub.Stack, private *hub.PrivateArray, from *hub.BaseValueDef) (value string, err error) {
+	switch from.From {
+	case hub.HeapOriginName:
+		return queryFromHeap(stack, "{{.origin."+from.Content+"}}")
+	case "heap":
+		return queryFromHeap(stack, "{{."+from.Content+"}}")
+	}
+
 	result, err := GetParameterRawValue(stack, private, from)
-	if err == nil {
-		return result.(string), err
+	if err != nil {
+		return "", err
+	}
+	value, ok := result.(string)
+	if !ok {
+		str := "type " + from.From + "的值不是字符串"
+		logger.LogS().Errorln(str)
+		return "", NewStatusError(hub.TmsErrorUnknownFromId, http.StatusInternalServerError, str, nil)
 	}
-	return "", err
+	return value, nil
 }
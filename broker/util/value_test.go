@@ -0,0 +1,36 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jasony62/tms-go-apihub/hub"
+)
+
+func TestGetParameterValueFromHeap(t *testing.T) {
+	items := []interface{}{map[string]interface{}{"id": "a"}, map[string]interface{}{"id": "b"}}
+	stack := &hub.Stack{Heap: hub.NewHeap(map[string]interface{}{
+		hub.HeapOriginName: map[string]interface{}{"items": items, "count": float64(2)},
+		"result":           map[string]interface{}{"items": items},
+	})}
+
+	tests := []struct {
+		from hub.BaseValueDef
+		raw  interface{}
+		str  string
+	}{
+		{hub.BaseValueDef{From: "origin", Content: "items"}, items, "[map[id:a] map[id:b]]"},
+		{hub.BaseValueDef{From: "origin", Content: "count"}, float64(2), "2"},
+		{hub.BaseValueDef{From: "heap", Content: "result.items"}, items, "[map[id:a] map[id:b]]"},
+	}
+	for _, tt := range tests {
+		raw, err := GetParameterRawValue(stack, nil, &tt.from)
+		if err != nil || !reflect.DeepEqual(raw, tt.raw) {
+			t.Errorf("raw %v = %v, %v", tt.from, raw, err)
+		}
+		str, err := GetParameterStringValue(stack, nil, &tt.from)
+		if err != nil || str != tt.str {
+			t.Errorf("string %v = %q, %v", tt.from, str, err)
+		}
+	}
+}
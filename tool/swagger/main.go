@@ -94,13 +94,18 @@ func parseParameters(params openapi3.Parameters) {
 			args := Args{In: "header", Name: param.Value.Name, Value: Value{From: "header", Content: param.Value.Name}}
 			apiHubHttpConf.Args = append(apiHubHttpConf.Args, args)
 		case "path":
-			//替换路径，RESTful接口，暂时不支持
+			//替换url路径中的{name}
+			args := Args{In: "path", Name: param.Value.Name, Value: Value{From: "query", Content: param.Value.Name}}
+			apiHubHttpConf.Args = append(apiHubHttpConf.Args, args)
 		}
 	}
 }
 
 //Operation-RequestBody
 func parseRequestBody(body *openapi3.RequestBodyRef) {
+	if body == nil {
+		return
+	}
 	if body.Ref != "" {
 		klog.Infoln("Operation-RequestBody has ref, Not supported!")
 		return
@@ -147,7 +152,7 @@ func parseRequestBodies(bodies openapi3.RequestBodies) {
 
 func parsePathOperation(oper *openapi3.PathItem) {
 	if oper.Post != nil {
-		apiHubHttpConf.Method = "post"
+		apiHubHttpConf.Method = "POST"
 		if oper.Post.Parameters != nil {
 			parseParameters(oper.Post.Parameters)
 			parseRequestBody(oper.Post.RequestBody)
@@ -155,22 +160,33 @@ func parsePathOperation(oper *openapi3.PathItem) {
 		}
 	}
 	if oper.Get != nil {
-		apiHubHttpConf.Method = "get"
+		apiHubHttpConf.Method = "GET"
 		if oper.Get.Parameters != nil {
 			parseParameters(oper.Get.Parameters)
 			parseRequestBody(oper.Get.RequestBody)
 		}
 	}
 	if oper.Delete != nil {
-		apiHubHttpConf.Method = "delete"
+		apiHubHttpConf.Method = "DELETE"
+		if oper.Delete.Parameters != nil {
+			parseParameters(oper.Delete.Parameters)
+			parseRequestBody(oper.Delete.RequestBody)
+		}
 	}
 	if oper.Put != nil {
-		apiHubHttpConf.Method = "put"
+		apiHubHttpConf.Method = "PUT"
 		if oper.Put.Parameters != nil {
 			parseParameters(oper.Put.Parameters)
 			parseRequestBody(oper.Put.RequestBody)
 		}
 	}
+	if oper.Patch != nil {
+		apiHubHttpConf.Method = "PATCH"
+		if oper.Patch.Parameters != nil {
+			parseParameters(oper.Patch.Parameters)
+			parseRequestBody(oper.Patch.RequestBody)
+		}
+	}
 }
 func parsePaths(api *openapi3.T) {
 	for p, oper := range api.Paths {